@@ -5,17 +5,13 @@ package buttons
 
 import (
 	"github.com/sigtot/elevio"
-	"github.com/sigtot/sanntid/mac"
 	"github.com/sigtot/sanntid/types"
-	"github.com/sigtot/sanntid/utils"
 )
 
 // StartButtonHandler starts a go-routine that listens for button events on the buttonEvents channel,
 // and translates the received event to a call type and sends it on the callsForSale channel, which is then received
-// by a seller.
-func StartButtonHandler(buttonEvents chan elevio.ButtonEvent, callsForSale chan types.Call) {
-	ID, err := mac.GetMacAddr()
-	utils.OkOrPanic(err)
+// by a seller. Cab calls are tagged with the elevatorID.
+func StartButtonHandler(buttonEvents chan elevio.ButtonEvent, callsForSale chan types.Call, elevatorID string) {
 	go func() {
 		for {
 			buttonEvent := <-buttonEvents
@@ -25,7 +21,7 @@ func StartButtonHandler(buttonEvents chan elevio.ButtonEvent, callsForSale chan
 			} else if buttonEvent.Button == elevio.BtnHallDown {
 				call = types.Call{Type: types.Hall, Dir: types.Down, Floor: buttonEvent.Floor}
 			} else {
-				call = types.Call{Type: types.Cab, Dir: types.InvalidDir, Floor: buttonEvent.Floor, ElevatorID: ID}
+				call = types.Call{Type: types.Cab, Dir: types.InvalidDir, Floor: buttonEvent.Floor, ElevatorID: elevatorID}
 			}
 			callsForSale <- call
 		}
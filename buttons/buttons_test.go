@@ -3,6 +3,7 @@ package buttons
 import (
 	"fmt"
 	"github.com/sigtot/elevio"
+	"github.com/sigtot/sanntid/driver"
 	"github.com/sigtot/sanntid/types"
 	"testing"
 )
@@ -12,11 +13,11 @@ const elevServerAddr = "localhost:15657"
 const numElevFloors = 4
 
 func TestStartButtonHandler(t *testing.T) {
-	elevio.Init(elevServerAddr, numElevFloors)
+	drv := driver.NewElevio(elevServerAddr, numElevFloors)
 	callsForSale := make(chan types.Call)
 	buttonEvents := make(chan elevio.ButtonEvent)
-	go elevio.PollButtons(buttonEvents)
-	StartButtonHandler(buttonEvents, callsForSale)
+	go drv.PollButtons(buttonEvents)
+	StartButtonHandler(buttonEvents, callsForSale, "buttons-test")
 	for {
 		call := <-callsForSale
 		fmt.Printf("%+v\n", call)
@@ -5,15 +5,12 @@ package buyer
 
 import (
 	"encoding/json"
-	"github.com/sigtot/sanntid/mac"
+	"github.com/sigtot/sanntid/config"
 	"github.com/sigtot/sanntid/pubsub"
 	"github.com/sigtot/sanntid/types"
 	"github.com/sigtot/sanntid/utils"
-	"github.com/sirupsen/logrus"
 )
 
-const numFloors = 4
-const topFloor = numFloors - 1
 const bottomFloor = 0
 const moduleName = "BUYER"
 
@@ -23,20 +20,39 @@ type PriceCalculator interface {
 	GetPrice(types.Call) int
 }
 
+// OrderTaker is the interface that wraps the AddOrder method.
+// It is needed by the buyer to add bought orders to the queue. The purchase is acknowledged when AddOrder returns,
+// so it must not return before the order is safely stored.
+type OrderTaker interface {
+	AddOrder(types.Order)
+}
+
+// BidGate is the interface that wraps the MayBid method.
+// A buyer only bids on hall calls while all of its bid gates allow it.
+// Own cab calls are always bid on, as no other elevator can deliver them.
+type BidGate interface {
+	MayBid() bool
+}
+
 // StartBuying starts a buyer that bids on and buys calls.
 // A buyer subscribes to sale propositions and sales.
 // A buyer publishes bids and sale acknowledgements.
-// A PriceCalculator interface is used to get the price on a call.
-func StartBuying(priceCalc PriceCalculator, newOrders chan types.Order) {
-	bidPubChan := pubsub.StartPublisher(pubsub.BidDiscoveryPort)
-	ackPubChan := pubsub.StartPublisher(pubsub.AckDiscoveryPort)
-	forSaleSubChan, _ := pubsub.StartSubscriber(pubsub.SalesDiscoveryPort, pubsub.SalesTopic)
-	soldToSubChan, _ := pubsub.StartSubscriber(pubsub.SoldToDiscoveryPort, pubsub.SoldToTopic)
-
-	elevatorID, err := mac.GetMacAddr()
-	utils.OkOrPanic(err)
+// A PriceCalculator interface is used to get the price on a call, and bought orders are given to orderTaker.
+// The elevatorID is used to identify bids, acks and own cab calls.
+func StartBuying(
+	cfg config.Config,
+	priceCalc PriceCalculator,
+	orderTaker OrderTaker,
+	elevatorID string,
+	gates ...BidGate) {
+	ports := pubsub.GetDiscoveryPorts(cfg.Network.DiscoveryBasePort)
+	bidPubChan := pubsub.StartPublisher(ports.Bid)
+	ackPubChan := pubsub.StartPublisher(ports.Ack)
+	forSaleSubChan, _ := pubsub.StartSubscriber(ports.Sales, pubsub.SalesTopic)
+	soldToSubChan, _ := pubsub.StartSubscriber(ports.SoldTo, pubsub.SoldToTopic)
+	topFloor := cfg.TopFloor()
 
-	var log = logrus.New()
+	var log = utils.NewLogger()
 
 	go func() {
 		for {
@@ -60,6 +76,11 @@ func StartBuying(priceCalc PriceCalculator, newOrders chan types.Order) {
 					break
 				}
 
+				if call.Type == types.Hall && !mayBid(gates) {
+					utils.LogCall(log, moduleName, "Not bidding on call", call)
+					break
+				}
+
 				// Calculate price and bid on call for sale
 				price := priceCalc.GetPrice(call)
 				bid := types.Bid{Call: call, Price: price, ElevatorID: elevatorID}
@@ -76,12 +97,12 @@ func StartBuying(priceCalc PriceCalculator, newOrders chan types.Order) {
 				utils.OkOrPanic(err)
 
 				if soldTo.ElevatorID == elevatorID {
-					// Send acknowledgement and handle order if sold to this bidder
+					// Handle order and send acknowledgement if sold to this bidder
+					orderTaker.AddOrder(types.Order{Call: soldTo.Call})
 					ack := types.Ack{Bid: soldTo.Bid}
 					js, err := json.Marshal(ack)
 					utils.OkOrPanic(err)
 					ackPubChan <- js
-					newOrders <- types.Order{Call: soldTo.Call}
 
 					utils.LogAck(log, moduleName, "Bought order", ack)
 				}
@@ -89,3 +110,13 @@ func StartBuying(priceCalc PriceCalculator, newOrders chan types.Order) {
 		}
 	}()
 }
+
+// mayBid returns true if all bid gates allow bidding.
+func mayBid(gates []BidGate) bool {
+	for _, gate := range gates {
+		if !gate.MayBid() {
+			return false
+		}
+	}
+	return true
+}
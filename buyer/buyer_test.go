@@ -2,7 +2,7 @@ package buyer
 
 import (
 	"encoding/json"
-	"github.com/sigtot/sanntid/mac"
+	"github.com/sigtot/sanntid/config"
 	"github.com/sigtot/sanntid/pubsub"
 	"github.com/sigtot/sanntid/types"
 	"testing"
@@ -15,18 +15,21 @@ func (pc *MockPriceCalculator) GetPrice(call types.Call) int {
 	return 2
 }
 
+type MockOrderTaker chan types.Order
+
+func (ot MockOrderTaker) AddOrder(order types.Order) {
+	ot <- order
+}
+
 func TestBuyer(t *testing.T) {
 	forSalePubChan := pubsub.StartPublisher(pubsub.SalesDiscoveryPort)
 	soldToPubChan := pubsub.StartPublisher(pubsub.SoldToDiscoveryPort)
 
-	elevatorID, err := mac.GetMacAddr()
-	if err != nil {
-		t.Fatalf("Could not get mac addr %s\n", err.Error())
-	}
+	elevatorID := "buyer-test"
 
 	priceCalc := MockPriceCalculator{}
 	newOrders := make(chan types.Order)
-	StartBuying(&priceCalc, newOrders)
+	StartBuying(config.Default(), &priceCalc, MockOrderTaker(newOrders), elevatorID)
 
 	// Sell call
 	call := types.Call{Type: types.Hall, Floor: 3, Dir: types.Down, ElevatorID: ""}
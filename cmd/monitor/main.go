@@ -0,0 +1,55 @@
+/*
+Command monitor subscribes to the telemetry topic and prints the state of every elevator on the network
+as it is received.
+*/
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/sigtot/elevio"
+	"github.com/sigtot/sanntid/pubsub"
+	"github.com/sigtot/sanntid/types"
+	"github.com/sigtot/sanntid/utils"
+)
+
+const moduleName = "MONITOR"
+const logString = "%-15s%s"
+
+func main() {
+	basePort := flag.Int("discovery-base-port", pubsub.DefaultDiscoveryBasePort, "discovery port of the first topic")
+	local := flag.Bool("local", false, "only discover publishers on this machine")
+	flag.Parse()
+
+	log := utils.NewLogger()
+	if *local {
+		pubsub.SetDiscoveryMode(pubsub.DiscoveryLocal)
+	}
+	ports := pubsub.GetDiscoveryPorts(*basePort)
+	telemetrySubChan, _ := pubsub.StartSubscriber(ports.Telemetry, pubsub.TelemetryTopic)
+	utils.Log(log, moduleName, "Listening for elevator telemetry")
+
+	for js := range telemetrySubChan {
+		var state types.ElevatorState
+		if err := json.Unmarshal(js, &state); err != nil {
+			log.WithField("err", err).Warnf(logString, moduleName, "Could not decode telemetry")
+			continue
+		}
+		fmt.Println(format(state))
+	}
+}
+
+func format(state types.ElevatorState) string {
+	dir := map[elevio.MotorDirection]string{elevio.MdUp: "up", elevio.MdDown: "down", elevio.MdStop: "idle"}[state.Dir]
+	door := "closed"
+	if state.DoorOpen {
+		door = "open"
+	}
+	goal := "none"
+	if state.NextGoal != nil {
+		goal = fmt.Sprintf("floor %d", state.NextGoal.Floor)
+	}
+	return fmt.Sprintf("%-20s pos %4.1f  %-4s  door %-6s  available %-5t  queue %d  next goal %s",
+		state.ElevatorID, state.Position, dir, door, state.Status.Available(), state.QueueLength, goal)
+}
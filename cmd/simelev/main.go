@@ -0,0 +1,90 @@
+/*
+Command simelev runs an elevator simulator serving the elevio protocol, which an elevator node connects to with -port.
+Buttons, the obstruction switch and the stop button are operated by typing commands on stdin:
+
+	up <floor>      press the hall up button at floor
+	down <floor>    press the hall down button at floor
+	cab <floor>     press the cab button at floor
+	obstruct        toggle the obstruction switch
+	stop            toggle the stop button
+	status          print the state of the elevator
+*/
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/sigtot/elevio"
+	"github.com/sigtot/sanntid/simulator"
+	"github.com/sigtot/sanntid/utils"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const moduleName = "SIMULATOR"
+const logString = "%-15s%s"
+
+func main() {
+	defaults := simulator.DefaultConfig()
+	port := flag.Int("port", 15657, "port to serve the elevio protocol on")
+	numFloors := flag.Int("floors", defaults.NumFloors, "number of floors")
+	travelTime := flag.Duration("travel-time", defaults.TravelTime, "time to travel between two floors")
+	startPosition := flag.Float64("start", defaults.StartPosition, "position in floors at startup")
+	flag.Parse()
+
+	log := utils.NewLogger()
+	sim := simulator.New(simulator.Config{
+		NumFloors:     *numFloors,
+		TravelTime:    *travelTime,
+		SensorWidth:   defaults.SensorWidth,
+		StartPosition: *startPosition,
+	})
+
+	addr := fmt.Sprintf(":%d", *port)
+	go func() {
+		err := sim.ListenAndServe(addr)
+		log.WithField("err", err).Fatalf(logString, moduleName, "Server stopped")
+	}()
+	log.WithField("addr", addr).Infof(logString, moduleName, "Serving elevio protocol")
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if err := handleCommand(sim, strings.Fields(scanner.Text())); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+func handleCommand(sim *simulator.Sim, args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	buttons := map[string]elevio.ButtonType{
+		"up":   elevio.BtnHallUp,
+		"down": elevio.BtnHallDown,
+		"cab":  elevio.BtnCab,
+	}
+	switch args[0] {
+	case "up", "down", "cab":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: %s <floor>", args[0])
+		}
+		floor, err := strconv.Atoi(args[1])
+		if err != nil {
+			return err
+		}
+		sim.PressButton(buttons[args[0]], floor)
+	case "obstruct":
+		sim.SetObstruction(!sim.Obstruction())
+	case "stop":
+		sim.SetStop(!sim.Stop())
+	case "status":
+		fmt.Printf("position %.2f, motor %d, floor indicator %d, door open %t, obstruction %t, stop %t\n",
+			sim.Position(), sim.MotorDirection(), sim.FloorIndicator(), sim.DoorOpen(), sim.Obstruction(), sim.Stop())
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	return nil
+}
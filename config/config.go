@@ -0,0 +1,342 @@
+/*
+Package config contains the configuration of an elevator node. The configuration is read from a YAML file,
+and each value can be overridden by an environment variable and a command line flag, in that order.
+Values tagged as live can be changed while the node is running, through a Store.
+*/
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sigtot/sanntid/pubsub"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"time"
+)
+
+// Config holds all tunables of an elevator node.
+// Fields tagged with live:"true" are applied by Store.Update while the node is running, see Store.
+type Config struct {
+	LogLevel     string             `yaml:"log_level" live:"true"`
+	NumFloors    int                `yaml:"num_floors"`
+	Elevator     ElevatorConfig     `yaml:"elevator"`
+	Parking      ParkingConfig      `yaml:"parking"`
+	Seller       SellerConfig       `yaml:"seller"`
+	Price        PriceConfig        `yaml:"price"`
+	OrderWatcher OrderWatcherConfig `yaml:"order_watcher"`
+	Network      NetworkConfig      `yaml:"network"`
+	Cluster      ClusterConfig      `yaml:"cluster"`
+}
+
+// ElevatorConfig holds the tunables of the elevator controller.
+// An elevator whose door is held open by an obstruction for longer than ObstructionTimeout is unavailable.
+// An elevator whose floor sensor does not change within TravelTimeout while the motor runs is faulty.
+// On shutdown, the elevator is given DrainTimeout to deliver its remaining orders.
+// FloorTravelTime is the initial estimate of the travel time between two floors, which is refined by measurement.
+// ClearingPolicy decides which orders are delivered when the elevator stops at a floor:
+// ClearAll delivers every order at the floor, while ClearDirection only delivers the cab orders and the hall orders
+// in the direction the elevator leaves in.
+type ElevatorConfig struct {
+	ServerPort         int           `yaml:"server_port" flag:"port"`
+	DoorOpenTime       time.Duration `yaml:"door_open_time" live:"true"`
+	InitTimeout        time.Duration `yaml:"init_timeout"`
+	ObstructionTimeout time.Duration `yaml:"obstruction_timeout" live:"true"`
+	TravelTimeout      time.Duration `yaml:"travel_timeout" live:"true"`
+	DrainTimeout       time.Duration `yaml:"drain_timeout" live:"true"`
+	FloorTravelTime    time.Duration `yaml:"floor_travel_time"`
+	ClearingPolicy     string        `yaml:"clearing_policy" live:"true"`
+}
+
+// ParkingConfig holds the settings for parking idle elevators. An elevator that has been idle for Timeout is sent
+// to the closest of HomeFloors not taken by another idle elevator. Parking is disabled when HomeFloors is empty.
+type ParkingConfig struct {
+	Timeout    time.Duration `yaml:"timeout" live:"true"`
+	HomeFloors []int         `yaml:"home_floors" live:"true"`
+}
+
+// SellerConfig holds the timings of the bidding rounds run by the seller.
+type SellerConfig struct {
+	BiddingRoundDuration time.Duration `yaml:"bidding_round_duration" live:"true"`
+	AckWaitDuration      time.Duration `yaml:"ack_wait_duration" live:"true"`
+	SaleTTL              time.Duration `yaml:"sale_ttl" live:"true"`
+}
+
+// PriceConfig holds the weights of the price function used when bidding on calls.
+// A delivery delay penalty, weighted by DeliveryDelayWeight, is added for every DeliveryDelayTick that passes
+// after DeliveryDelay without any delivery. UnavailablePenalty is added to hall calls while the elevator is unavailable.
+type PriceConfig struct {
+	CommunityWeight     float64       `yaml:"community_weight" live:"true"`
+	IndividualWeight    float64       `yaml:"individual_weight" live:"true"`
+	WaitWeight          float64       `yaml:"wait_weight" live:"true"`
+	TravelWeight        float64       `yaml:"travel_weight" live:"true"`
+	DeliveryDelayWeight float64       `yaml:"delivery_delay_weight" live:"true"`
+	DeliveryDelay       time.Duration `yaml:"delivery_delay"`
+	DeliveryDelayTick   time.Duration `yaml:"delivery_delay_tick"`
+	UnavailablePenalty  int           `yaml:"unavailable_penalty" live:"true"`
+}
+
+// OrderWatcherConfig holds the timings of the order watcher and db distributor.
+// Orders not delivered within BaseTTD, plus or minus half of RandTTDOffset, are resold.
+// At startup, a node asks its peers for its cab orders, and collects their answers for CabRestoreTimeout.
+type OrderWatcherConfig struct {
+	BaseTTD              time.Duration `yaml:"base_ttd" live:"true"`
+	RandTTDOffset        time.Duration `yaml:"rand_ttd_offset" live:"true"`
+	DbTraversalInterval  time.Duration `yaml:"db_traversal_interval"`
+	DbDistributeInterval time.Duration `yaml:"db_distribute_interval"`
+	CabRestoreTimeout    time.Duration `yaml:"cab_restore_timeout"`
+}
+
+// NetworkConfig holds the network settings. The discovery ports of all topics are counted from DiscoveryBasePort.
+// DiscoveryMode is either pubsub.DiscoveryBroadcast or pubsub.DiscoveryLocal.
+type NetworkConfig struct {
+	DiscoveryBasePort int    `yaml:"discovery_base_port"`
+	DiscoveryMode     string `yaml:"discovery_mode" live:"true"`
+}
+
+// ClusterConfig holds the settings for keeping the configuration consistent across the nodes.
+// OnMismatch decides what a node does when a peer has a different SharedConfig:
+// MismatchWarn only logs it, while MismatchRefuse also stops bidding until the configurations agree.
+// A node with PushConfig set publishes its SharedConfig, which is adopted by all nodes with a lower ConfigVersion.
+// The position, direction and queue of the elevator are published every TelemetryInterval.
+type ClusterConfig struct {
+	OnMismatch        string        `yaml:"on_mismatch" live:"true"`
+	PushConfig        bool          `yaml:"push_config" live:"true"`
+	ConfigVersion     int           `yaml:"config_version" live:"true"`
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+	TelemetryInterval time.Duration `yaml:"telemetry_interval" live:"true"`
+}
+
+// Clearing policies
+const (
+	ClearAll       = "all"
+	ClearDirection = "direction"
+)
+
+// Actions on config mismatch between nodes
+const (
+	MismatchWarn   = "warn"
+	MismatchRefuse = "refuse"
+)
+
+// SharedConfig is the part of the configuration that must be equal on all nodes for the auctions to be fair.
+type SharedConfig struct {
+	NumFloors     int
+	Price         PriceConfig
+	BaseTTD       time.Duration
+	RandTTDOffset time.Duration
+}
+
+// Default returns the default configuration.
+func Default() Config {
+	return Config{
+		LogLevel:  "info",
+		NumFloors: 4,
+		Elevator: ElevatorConfig{
+			ServerPort:         15657,
+			DoorOpenTime:       3000 * time.Millisecond,
+			InitTimeout:        3000 * time.Millisecond,
+			ObstructionTimeout: 10000 * time.Millisecond,
+			TravelTimeout:      4000 * time.Millisecond,
+			DrainTimeout:       30000 * time.Millisecond,
+			FloorTravelTime:    2000 * time.Millisecond,
+			ClearingPolicy:     ClearDirection,
+		},
+		Parking: ParkingConfig{
+			Timeout:    10000 * time.Millisecond,
+			HomeFloors: []int{0},
+		},
+		Seller: SellerConfig{
+			BiddingRoundDuration: 10 * time.Millisecond,
+			AckWaitDuration:      10 * time.Millisecond,
+			SaleTTL:              400 * time.Millisecond,
+		},
+		Price: PriceConfig{
+			CommunityWeight:     2,
+			IndividualWeight:    1,
+			WaitWeight:          3,
+			TravelWeight:        1,
+			DeliveryDelayWeight: 1,
+			DeliveryDelay:       12000 * time.Millisecond,
+			DeliveryDelayTick:   1000 * time.Millisecond,
+			UnavailablePenalty:  1000,
+		},
+		OrderWatcher: OrderWatcherConfig{
+			BaseTTD:              10000 * time.Millisecond,
+			RandTTDOffset:        2000 * time.Millisecond,
+			DbTraversalInterval:  500 * time.Millisecond,
+			DbDistributeInterval: 10000 * time.Millisecond,
+			CabRestoreTimeout:    2000 * time.Millisecond,
+		},
+		Network: NetworkConfig{
+			DiscoveryBasePort: pubsub.DefaultDiscoveryBasePort,
+			DiscoveryMode:     pubsub.DiscoveryBroadcast,
+		},
+		Cluster: ClusterConfig{
+			OnMismatch:        MismatchWarn,
+			PushConfig:        false,
+			ConfigVersion:     0,
+			HeartbeatInterval: 1000 * time.Millisecond,
+			TelemetryInterval: 500 * time.Millisecond,
+		},
+	}
+}
+
+// Load returns the default configuration, overridden by the YAML file at path (if path is not empty),
+// then by environment variables and finally by the flags that were set on the command line.
+// The resulting configuration is validated.
+func Load(path string, flags *Flags) (Config, error) {
+	cfg := Default()
+	if path != "" {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return cfg, err
+		}
+		if err := yaml.UnmarshalStrict(buf, &cfg); err != nil {
+			return cfg, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	if err := applyEnv(&cfg); err != nil {
+		return cfg, err
+	}
+	if flags != nil {
+		if err := flags.apply(&cfg); err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, cfg.Validate()
+}
+
+// Validate checks that all values of the configuration are within sensible bounds.
+func (cfg Config) Validate() error {
+	if _, err := logrus.ParseLevel(cfg.LogLevel); err != nil {
+		return fmt.Errorf("log_level: %s", err)
+	}
+	if cfg.NumFloors < 2 {
+		return errors.New("num_floors must be at least 2")
+	}
+	if !validPort(cfg.Elevator.ServerPort) {
+		return errors.New("elevator.server_port must be a valid port")
+	}
+	if !validPort(cfg.Network.DiscoveryBasePort) || !validPort(cfg.Network.DiscoveryBasePort+pubsub.NumTopics-1) {
+		return errors.New("network.discovery_base_port must leave room for a valid port for every topic")
+	}
+	if cfg.Elevator.ClearingPolicy != ClearAll && cfg.Elevator.ClearingPolicy != ClearDirection {
+		return fmt.Errorf("elevator.clearing_policy must be %s or %s", ClearAll, ClearDirection)
+	}
+	if cfg.Network.DiscoveryMode != pubsub.DiscoveryBroadcast && cfg.Network.DiscoveryMode != pubsub.DiscoveryLocal {
+		return fmt.Errorf("network.discovery_mode must be %s or %s", pubsub.DiscoveryBroadcast, pubsub.DiscoveryLocal)
+	}
+
+	durations := map[string]time.Duration{
+		"elevator.door_open_time":              cfg.Elevator.DoorOpenTime,
+		"elevator.init_timeout":                cfg.Elevator.InitTimeout,
+		"elevator.obstruction_timeout":         cfg.Elevator.ObstructionTimeout,
+		"elevator.travel_timeout":              cfg.Elevator.TravelTimeout,
+		"elevator.drain_timeout":               cfg.Elevator.DrainTimeout,
+		"elevator.floor_travel_time":           cfg.Elevator.FloorTravelTime,
+		"parking.timeout":                      cfg.Parking.Timeout,
+		"seller.bidding_round_duration":        cfg.Seller.BiddingRoundDuration,
+		"seller.ack_wait_duration":             cfg.Seller.AckWaitDuration,
+		"seller.sale_ttl":                      cfg.Seller.SaleTTL,
+		"price.delivery_delay_tick":            cfg.Price.DeliveryDelayTick,
+		"order_watcher.db_traversal_interval":  cfg.OrderWatcher.DbTraversalInterval,
+		"order_watcher.db_distribute_interval": cfg.OrderWatcher.DbDistributeInterval,
+		"order_watcher.cab_restore_timeout":    cfg.OrderWatcher.CabRestoreTimeout,
+		"cluster.heartbeat_interval":           cfg.Cluster.HeartbeatInterval,
+		"cluster.telemetry_interval":           cfg.Cluster.TelemetryInterval,
+	}
+	for key, d := range durations {
+		if d <= 0 {
+			return fmt.Errorf("%s must be positive", key)
+		}
+	}
+	for _, floor := range cfg.Parking.HomeFloors {
+		if floor < 0 || floor > cfg.TopFloor() {
+			return fmt.Errorf("parking.home_floors must be between 0 and %d", cfg.TopFloor())
+		}
+	}
+	if cfg.Price.DeliveryDelay < 0 {
+		return errors.New("price.delivery_delay must not be negative")
+	}
+	if cfg.Price.UnavailablePenalty < 0 {
+		return errors.New("price.unavailable_penalty must not be negative")
+	}
+	if cfg.OrderWatcher.RandTTDOffset <= 0 || cfg.OrderWatcher.RandTTDOffset/2 >= cfg.OrderWatcher.BaseTTD {
+		return errors.New("order_watcher.rand_ttd_offset must be positive and less than twice order_watcher.base_ttd")
+	}
+
+	weights := map[string]float64{
+		"price.community_weight":      cfg.Price.CommunityWeight,
+		"price.individual_weight":     cfg.Price.IndividualWeight,
+		"price.wait_weight":           cfg.Price.WaitWeight,
+		"price.travel_weight":         cfg.Price.TravelWeight,
+		"price.delivery_delay_weight": cfg.Price.DeliveryDelayWeight,
+	}
+	for key, w := range weights {
+		if w < 0 {
+			return fmt.Errorf("%s must not be negative", key)
+		}
+	}
+
+	if cfg.Cluster.OnMismatch != MismatchWarn && cfg.Cluster.OnMismatch != MismatchRefuse {
+		return fmt.Errorf("cluster.on_mismatch must be %s or %s", MismatchWarn, MismatchRefuse)
+	}
+	if cfg.Cluster.ConfigVersion < 0 {
+		return errors.New("cluster.config_version must not be negative")
+	}
+	return nil
+}
+
+// TopFloor returns the index of the top floor.
+func (cfg Config) TopFloor() int {
+	return cfg.NumFloors - 1
+}
+
+// Shared returns the part of the configuration that must be equal on all nodes.
+func (cfg Config) Shared() SharedConfig {
+	return SharedConfig{
+		NumFloors:     cfg.NumFloors,
+		Price:         cfg.Price,
+		BaseTTD:       cfg.OrderWatcher.BaseTTD,
+		RandTTDOffset: cfg.OrderWatcher.RandTTDOffset,
+	}
+}
+
+// WithShared returns a copy of the configuration with the shared part replaced by shared.
+func (cfg Config) WithShared(shared SharedConfig) Config {
+	cfg.NumFloors = shared.NumFloors
+	cfg.Price = shared.Price
+	cfg.OrderWatcher.BaseTTD = shared.BaseTTD
+	cfg.OrderWatcher.RandTTDOffset = shared.RandTTDOffset
+	return cfg
+}
+
+// KeepNewerShared returns a copy of the configuration with the shared part and config version of current,
+// if current has a higher config version. Reloading the config file thus does not revert a configuration
+// adopted from a peer.
+func (cfg Config) KeepNewerShared(current Config) Config {
+	if cfg.Cluster.ConfigVersion >= current.Cluster.ConfigVersion {
+		return cfg
+	}
+	cfg = cfg.WithShared(current.Shared())
+	cfg.Cluster.ConfigVersion = current.Cluster.ConfigVersion
+	return cfg
+}
+
+// Fingerprint returns a short hash identifying the shared configuration.
+func (shared SharedConfig) Fingerprint() string {
+	js, err := json.Marshal(shared)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(js)
+	return hex.EncodeToString(sum[:8])
+}
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+num_floors: 6
+elevator:
+  door_open_time: 2s
+price:
+  wait_weight: 4
+`
+
+func TestLoad(t *testing.T) {
+	f, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(testConfig); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Setenv("SANNTID_PRICE_WAIT_WEIGHT", "5"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv("SANNTID_PRICE_WAIT_WEIGHT")
+	if err := os.Setenv("SANNTID_PARKING_HOME_FLOORS", "0, 3"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv("SANNTID_PARKING_HOME_FLOORS")
+	if err := os.Setenv("SANNTID_ELEVATOR_SERVER_PORT", "15000"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv("SANNTID_ELEVATOR_SERVER_PORT")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := RegisterFlags(fs)
+	if err := fs.Parse([]string{"-port", "15658", "-seller.ack-wait-duration", "20ms"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(f.Name(), flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Default()
+	expected.NumFloors = 6                                  // From file
+	expected.Elevator.DoorOpenTime = 2 * time.Second        // From file
+	expected.Price.WaitWeight = 5                           // Env overrides file
+	expected.Parking.HomeFloors = []int{0, 3}               // From env
+	expected.Elevator.ServerPort = 15658                    // Flag overrides env
+	expected.Seller.AckWaitDuration = 20 * time.Millisecond // From flag
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Fatalf("Expected config\n%+v\nbut got\n%+v\n", expected, cfg)
+	}
+}
+
+func TestLoadUnknownKey(t *testing.T) {
+	f, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("num_flors: 4\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(f.Name(), nil); err == nil {
+		t.Fatal("Expected error on misspelled key")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("Default config is invalid: %s\n", err)
+	}
+
+	cfg := Default()
+	cfg.NumFloors = 1
+	if cfg.Validate() == nil {
+		t.Fatal("Expected error on too few floors")
+	}
+
+	cfg = Default()
+	cfg.Price.TravelWeight = -1
+	if cfg.Validate() == nil {
+		t.Fatal("Expected error on negative weight")
+	}
+
+	cfg = Default()
+	cfg.Parking.HomeFloors = []int{0, 4}
+	if cfg.Validate() == nil {
+		t.Fatal("Expected error on home floor above top floor")
+	}
+
+	cfg = Default()
+	cfg.Elevator.ClearingPolicy = "nearest"
+	if cfg.Validate() == nil {
+		t.Fatal("Expected error on unknown clearing policy")
+	}
+
+	cfg = Default()
+	cfg.Elevator.DoorOpenTime = 0
+	if cfg.Validate() == nil {
+		t.Fatal("Expected error on zero door open time")
+	}
+}
+
+func TestExampleConfigIsDefault(t *testing.T) {
+	cfg, err := Load("../config.yml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Fatalf("Example config\n%+v\ndiffers from default\n%+v\n", cfg, Default())
+	}
+}
+
+func TestKeepNewerShared(t *testing.T) {
+	adopted := Default()
+	adopted.Price.TravelWeight = 5
+	adopted.Cluster.ConfigVersion = 2
+
+	reloaded := Default()
+	reloaded.Seller.SaleTTL = time.Second
+	kept := reloaded.KeepNewerShared(adopted)
+	if kept.Price.TravelWeight != 5 || kept.Cluster.ConfigVersion != 2 {
+		t.Fatalf("Expected adopted shared config to be kept but got %+v\n", kept)
+	}
+	if kept.Seller.SaleTTL != time.Second {
+		t.Fatal("Expected values outside the shared config to be reloaded")
+	}
+
+	reloaded.Cluster.ConfigVersion = 3
+	if kept := reloaded.KeepNewerShared(adopted); kept.Price.TravelWeight != Default().Price.TravelWeight {
+		t.Fatal("Expected shared config of a newer version to be reloaded")
+	}
+}
@@ -0,0 +1,178 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const envPrefix = "SANNTID_"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// field is a single configuration value, addressed by its dotted YAML key, e.g. elevator.door_open_time.
+type field struct {
+	key      string
+	flagName string
+	live     bool
+	value    reflect.Value
+}
+
+// Flags holds the configuration values set on the command line.
+type Flags struct {
+	set map[string]string
+}
+
+// flagValue records the value of a configuration flag when it is set.
+type flagValue struct {
+	key   string
+	def   string
+	flags *Flags
+}
+
+func (fv *flagValue) String() string {
+	if fv == nil {
+		return ""
+	}
+	return fv.def
+}
+
+func (fv *flagValue) Set(s string) error {
+	fv.flags.set[fv.key] = s
+	return nil
+}
+
+// RegisterFlags registers a flag for every configuration value on fs.
+// Flags are named after the YAML key with dashes, e.g. -elevator.door-open-time, unless the field has a flag tag.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	flags := Flags{set: make(map[string]string)}
+	def := Default()
+	for _, f := range fields(&def) {
+		fs.Var(&flagValue{key: f.key, def: formatValue(f.value), flags: &flags}, f.flagName,
+			fmt.Sprintf("overrides %s (env %s)", f.key, envName(f.key)))
+	}
+	return &flags
+}
+
+// apply sets the configuration values that were given on the command line.
+func (flags *Flags) apply(cfg *Config) error {
+	for _, f := range fields(cfg) {
+		if s, ok := flags.set[f.key]; ok {
+			if err := setValue(f.value, s); err != nil {
+				return fmt.Errorf("flag -%s: %s", f.flagName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// applyEnv sets the configuration values given as environment variables, e.g. SANNTID_ELEVATOR_DOOR_OPEN_TIME.
+func applyEnv(cfg *Config) error {
+	for _, f := range fields(cfg) {
+		if s, ok := os.LookupEnv(envName(f.key)); ok {
+			if err := setValue(f.value, s); err != nil {
+				return fmt.Errorf("%s: %s", envName(f.key), err)
+			}
+		}
+	}
+	return nil
+}
+
+// fields returns all configuration values in cfg, recursing into the nested config structs.
+func fields(cfg *Config) []field {
+	return structFields(reflect.ValueOf(cfg).Elem(), "")
+}
+
+func structFields(v reflect.Value, prefix string) (fs []field) {
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		key := prefix + strings.Split(sf.Tag.Get("yaml"), ",")[0]
+		if sf.Type.Kind() == reflect.Struct && sf.Type != durationType {
+			fs = append(fs, structFields(v.Field(i), key+".")...)
+			continue
+		}
+		flagName := sf.Tag.Get("flag")
+		if flagName == "" {
+			flagName = strings.Replace(key, "_", "-", -1)
+		}
+		live := sf.Tag.Get("live") == "true"
+		fs = append(fs, field{key: key, flagName: flagName, live: live, value: v.Field(i)})
+	}
+	return fs
+}
+
+func envName(key string) string {
+	return envPrefix + strings.ToUpper(strings.NewReplacer(".", "_").Replace(key))
+}
+
+// setValue parses s into the configuration value v.
+func setValue(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Int:
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(i))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Int {
+			return fmt.Errorf("unsupported config type %s", v.Type())
+		}
+		// Comma separated, e.g. 0,3
+		ints := []int{}
+		for _, part := range strings.Split(s, ",") {
+			if part = strings.TrimSpace(part); part == "" {
+				continue
+			}
+			i, err := strconv.Atoi(part)
+			if err != nil {
+				return err
+			}
+			ints = append(ints, i)
+		}
+		v.Set(reflect.ValueOf(ints))
+	default:
+		return fmt.Errorf("unsupported config type %s", v.Type())
+	}
+	return nil
+}
+
+func formatValue(v reflect.Value) string {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	if ints, ok := v.Interface().([]int); ok {
+		parts := make([]string, len(ints))
+		for i, n := range ints {
+			parts[i] = strconv.Itoa(n)
+		}
+		return strings.Join(parts, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Store holds the configuration of a running node. Modules given a Store read the values tagged as live
+// from it every time they use them, instead of keeping a copy from startup, so that an Update takes effect
+// without a restart. Values not tagged as live may be read once at startup.
+type Store struct {
+	mu  sync.RWMutex
+	cfg Config
+}
+
+// NewStore returns a store holding cfg.
+func NewStore(cfg Config) *Store {
+	return &Store{cfg: cfg}
+}
+
+// Get returns the current configuration.
+func (s *Store) Get() Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cfg
+}
+
+// Update applies the live values of newCfg, and returns the keys of the values that differ from the
+// current configuration but can only be changed by a restart. Nothing is applied if the resulting
+// configuration is invalid.
+func (s *Store) Update(newCfg Config) (needRestart []string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated := s.cfg
+	updatedFields := fields(&updated)
+	newFields := fields(&newCfg)
+	for i, f := range updatedFields {
+		if reflect.DeepEqual(f.value.Interface(), newFields[i].value.Interface()) {
+			continue
+		}
+		if f.live {
+			f.value.Set(newFields[i].value)
+		} else {
+			needRestart = append(needRestart, f.key)
+		}
+	}
+	if err := updated.Validate(); err != nil {
+		return nil, err
+	}
+	s.cfg = updated
+	return needRestart, nil
+}
+
+// NeedRestart returns the keys of the values of newCfg that differ from the current configuration but can only be
+// changed by a restart, without applying anything.
+func (s *Store) NeedRestart(newCfg Config) (needRestart []string) {
+	current := s.Get()
+	currentFields := fields(&current)
+	newFields := fields(&newCfg)
+	for i, f := range currentFields {
+		if !f.live && !reflect.DeepEqual(f.value.Interface(), newFields[i].value.Interface()) {
+			needRestart = append(needRestart, f.key)
+		}
+	}
+	return needRestart
+}
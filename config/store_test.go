@@ -0,0 +1,46 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStoreUpdate(t *testing.T) {
+	store := NewStore(Default())
+
+	newCfg := Default()
+	newCfg.Price.WaitWeight = 5
+	newCfg.OrderWatcher.BaseTTD = 20 * time.Second
+	newCfg.NumFloors = 6
+	if keys := store.NeedRestart(newCfg); !reflect.DeepEqual(keys, []string{"num_floors"}) {
+		t.Fatalf("Expected num_floors to need restart, but got %v\n", keys)
+	}
+	if store.Get().Price.WaitWeight == 5 {
+		t.Fatal("NeedRestart applied a live value")
+	}
+	needRestart, err := store.Update(newCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(needRestart, []string{"num_floors"}) {
+		t.Fatalf("Expected num_floors to need restart, but got %v\n", needRestart)
+	}
+
+	cfg := store.Get()
+	if cfg.Price.WaitWeight != 5 || cfg.OrderWatcher.BaseTTD != 20*time.Second {
+		t.Fatalf("Live values were not applied: %+v\n", cfg)
+	}
+	if cfg.NumFloors != Default().NumFloors {
+		t.Fatalf("Restart value was applied: %d floors\n", cfg.NumFloors)
+	}
+
+	invalidCfg := Default()
+	invalidCfg.Price.WaitWeight = -1
+	if _, err := store.Update(invalidCfg); err == nil {
+		t.Fatal("Expected error on invalid config")
+	}
+	if store.Get().Price.WaitWeight != 5 {
+		t.Fatal("Invalid config was applied")
+	}
+}
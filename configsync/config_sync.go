@@ -0,0 +1,162 @@
+/*
+Package configsync keeps the configuration consistent across the elevator nodes. Every node publishes a heartbeat
+with a fingerprint of its shared configuration, and warns, or refuses to bid, when its peers disagree.
+A node can also push its shared configuration, which is then adopted at runtime by the other nodes.
+*/
+package configsync
+
+import (
+	"encoding/json"
+	"github.com/sigtot/sanntid/config"
+	"github.com/sigtot/sanntid/pubsub"
+	"github.com/sigtot/sanntid/utils"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// Peers not heard from within this many heartbeat intervals are forgotten
+const peerTimeoutIntervals = 3
+
+const moduleName = "CONFIG SYNC"
+const logString = "%-15s%s"
+
+type configMsg struct {
+	SenderID    string
+	Fingerprint string
+	Version     int
+	Push        bool
+	Shared      config.SharedConfig
+}
+
+type peer struct {
+	fingerprint string
+	lastSeen    time.Time
+}
+
+// ConfigSync holds the fingerprints last heard from the peers.
+type ConfigSync struct {
+	store *config.Store
+	peers map[string]peer
+	mu    sync.Mutex
+}
+
+// StartConfigSync starts publishing config heartbeats for elevatorID, and listening for those of the peers.
+// Pushed configurations with a higher version than the current one are applied to the store, unless they differ
+// in values that need a restart.
+// A config sync publishes and subscribes to config heartbeats.
+func StartConfigSync(store *config.Store, elevatorID string) *ConfigSync {
+	cfg := store.Get()
+	ports := pubsub.GetDiscoveryPorts(cfg.Network.DiscoveryBasePort)
+	configPubChan := pubsub.StartPublisher(ports.Config)
+	configSubChan, _ := pubsub.StartSubscriber(ports.Config, pubsub.ConfigTopic)
+
+	cs := ConfigSync{store: store, peers: make(map[string]peer)}
+	log := utils.NewLogger()
+
+	go func() {
+		heartbeatTicker := time.NewTicker(cfg.Cluster.HeartbeatInterval)
+		defer heartbeatTicker.Stop()
+		refusedVersion := 0 // Pushes of this version are not retried, as the restart values only change with a restart
+		for {
+			select {
+			case <-heartbeatTicker.C:
+				cfg := store.Get()
+				shared := cfg.Shared()
+				msg := configMsg{
+					SenderID:    elevatorID,
+					Fingerprint: shared.Fingerprint(),
+					Version:     cfg.Cluster.ConfigVersion,
+					Push:        cfg.Cluster.PushConfig,
+					Shared:      shared,
+				}
+				js, err := json.Marshal(msg)
+				utils.OkOrPanic(err)
+				configPubChan <- js
+
+				cs.forgetStalePeers(peerTimeoutIntervals * cfg.Cluster.HeartbeatInterval)
+			case msgJson := <-configSubChan:
+				msg := configMsg{}
+				err := json.Unmarshal(msgJson, &msg)
+				utils.OkOrPanic(err)
+				if msg.SenderID == elevatorID {
+					break
+				}
+
+				if msg.Push && msg.Version > store.Get().Cluster.ConfigVersion && msg.Version != refusedVersion {
+					if !cs.adopt(log, msg) {
+						refusedVersion = msg.Version
+					}
+				}
+
+				own := store.Get().Shared().Fingerprint()
+				if changed := cs.updatePeer(msg.SenderID, msg.Fingerprint); changed && msg.Fingerprint != own {
+					log.WithFields(logrus.Fields{
+						"peer":             msg.SenderID,
+						"peer fingerprint": msg.Fingerprint,
+						"fingerprint":      own,
+					}).Warnf(logString, moduleName, "Peer has a different config")
+				}
+			}
+		}
+	}()
+	return &cs
+}
+
+// MayBid returns false if the node is configured to refuse bidding on config mismatch,
+// and any peer has a different shared configuration.
+func (cs *ConfigSync) MayBid() bool {
+	cfg := cs.store.Get()
+	if cfg.Cluster.OnMismatch != config.MismatchRefuse {
+		return true
+	}
+	own := cfg.Shared().Fingerprint()
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for _, p := range cs.peers {
+		if p.fingerprint != own {
+			return false
+		}
+	}
+	return true
+}
+
+// adopt applies the shared configuration pushed in msg to the store, and returns true if it was adopted.
+// A push that differs in values that need a restart is refused, as the node would otherwise claim a config version
+// it does not run. Such a node must be restarted with the pushed values to adopt it.
+func (cs *ConfigSync) adopt(log *logrus.Logger, msg configMsg) bool {
+	newCfg := cs.store.Get().WithShared(msg.Shared)
+	newCfg.Cluster.ConfigVersion = msg.Version
+	fields := logrus.Fields{"peer": msg.SenderID, "version": msg.Version}
+	if needRestart := cs.store.NeedRestart(newCfg); len(needRestart) > 0 {
+		log.WithFields(fields).WithField("keys", needRestart).Errorf(logString, moduleName,
+			"Refused pushed config, it differs in values that need a restart")
+		return false
+	}
+	if _, err := cs.store.Update(newCfg); err != nil {
+		log.WithFields(fields).WithField("err", err).Warnf(logString, moduleName, "Could not adopt pushed config")
+		return false
+	}
+	log.WithFields(fields).Infof(logString, moduleName, "Adopted pushed config")
+	return true
+}
+
+// updatePeer stores the fingerprint of a peer, and returns true if it is new or has changed.
+func (cs *ConfigSync) updatePeer(id string, fingerprint string) (changed bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	old, known := cs.peers[id]
+	cs.peers[id] = peer{fingerprint: fingerprint, lastSeen: time.Now()}
+	return !known || old.fingerprint != fingerprint
+}
+
+func (cs *ConfigSync) forgetStalePeers(timeout time.Duration) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for id, p := range cs.peers {
+		if time.Since(p.lastSeen) > timeout {
+			delete(cs.peers, id)
+		}
+	}
+}
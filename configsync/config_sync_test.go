@@ -0,0 +1,73 @@
+package configsync
+
+import (
+	"github.com/sigtot/sanntid/config"
+	"github.com/sigtot/sanntid/pubsub"
+	"testing"
+	"time"
+)
+
+const testDiscoveryBasePort = 42000
+
+func testConfig() config.Config {
+	cfg := config.Default()
+	cfg.Network.DiscoveryBasePort = testDiscoveryBasePort
+	cfg.Cluster.HeartbeatInterval = 100 * time.Millisecond
+	return cfg
+}
+
+func TestConfigSync(t *testing.T) {
+	pusherCfg := testConfig()
+	pusherCfg.Cluster.PushConfig = true
+	pusherCfg.Cluster.ConfigVersion = 1
+	pusherCfg.Price.WaitWeight = 5
+	pusherStore := config.NewStore(pusherCfg)
+
+	followerCfg := testConfig()
+	followerCfg.Cluster.OnMismatch = config.MismatchRefuse
+	followerStore := config.NewStore(followerCfg)
+
+	_ = StartConfigSync(pusherStore, "pusher")
+	follower := StartConfigSync(followerStore, "follower")
+
+	time.Sleep(2 * time.Second)
+
+	cfg := followerStore.Get()
+	if cfg.Price.WaitWeight != 5 || cfg.Cluster.ConfigVersion != 1 {
+		t.Fatalf("Pushed config was not adopted: %+v\n", cfg)
+	}
+	if !follower.MayBid() {
+		t.Fatal("Follower should bid with the same config as the pusher")
+	}
+}
+
+func TestConfigSyncRefusesRestartValues(t *testing.T) {
+	pusherCfg := testConfig()
+	pusherCfg.Network.DiscoveryBasePort = testDiscoveryBasePort + pubsub.NumTopics
+	pusherCfg.Cluster.PushConfig = true
+	pusherCfg.Cluster.ConfigVersion = 1
+	pusherCfg.Price.WaitWeight = 5
+	pusherCfg.NumFloors = 6
+	pusherStore := config.NewStore(pusherCfg)
+
+	followerCfg := testConfig()
+	followerCfg.Network.DiscoveryBasePort = pusherCfg.Network.DiscoveryBasePort
+	followerCfg.Cluster.OnMismatch = config.MismatchRefuse
+	followerStore := config.NewStore(followerCfg)
+
+	_ = StartConfigSync(pusherStore, "pusher")
+	follower := StartConfigSync(followerStore, "follower")
+
+	time.Sleep(2 * time.Second)
+
+	cfg := followerStore.Get()
+	if cfg.NumFloors != followerCfg.NumFloors {
+		t.Fatal("Number of floors should not change without a restart")
+	}
+	if cfg.Price.WaitWeight == 5 || cfg.Cluster.ConfigVersion != 0 {
+		t.Fatalf("Pushed config needing a restart was adopted: %+v\n", cfg)
+	}
+	if follower.MayBid() {
+		t.Fatal("Follower should refuse to bid with a different number of floors than the pusher")
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Package driver defines the interface between the elevator system and the elevator hardware,
+as well as its default implementation, which talks to an elevator server over TCP using the elevio package.
+*/
+package driver
+
+import (
+	"github.com/sigtot/elevio"
+)
+
+// Driver is the interface to the elevator hardware: the motor, the lamps and the sensors.
+// The Poll methods send sensor changes on the receiver channel, and are meant to be run as go-routines.
+type Driver interface {
+	SetMotorDirection(dir elevio.MotorDirection)
+	SetButtonLamp(button elevio.ButtonType, floor int, value bool)
+	SetFloorIndicator(floor int)
+	SetDoorOpenLamp(value bool)
+	SetStopLamp(value bool)
+	PollButtons(receiver chan<- elevio.ButtonEvent)
+	PollFloorSensor(receiver chan<- int)
+	PollStopButton(receiver chan<- bool)
+	PollObstructionSwitch(receiver chan<- bool)
+}
+
+// Elevio is the Driver for an elevator server, i.e. the physical elevator or the simulator, over TCP.
+type Elevio struct{}
+
+// NewElevio connects to the elevator server at addr, and returns a Driver for it.
+func NewElevio(addr string, numFloors int) *Elevio {
+	elevio.Init(addr, numFloors)
+	return &Elevio{}
+}
+
+func (e *Elevio) SetMotorDirection(dir elevio.MotorDirection) {
+	elevio.SetMotorDirection(dir)
+}
+
+func (e *Elevio) SetButtonLamp(button elevio.ButtonType, floor int, value bool) {
+	elevio.SetButtonLamp(button, floor, value)
+}
+
+func (e *Elevio) SetFloorIndicator(floor int) {
+	elevio.SetFloorIndicator(floor)
+}
+
+func (e *Elevio) SetDoorOpenLamp(value bool) {
+	elevio.SetDoorOpenLamp(value)
+}
+
+func (e *Elevio) SetStopLamp(value bool) {
+	elevio.SetStopLamp(value)
+}
+
+func (e *Elevio) PollButtons(receiver chan<- elevio.ButtonEvent) {
+	elevio.PollButtons(receiver)
+}
+
+func (e *Elevio) PollFloorSensor(receiver chan<- int) {
+	elevio.PollFloorSensor(receiver)
+}
+
+func (e *Elevio) PollStopButton(receiver chan<- bool) {
+	elevio.PollStopButton(receiver)
+}
+
+func (e *Elevio) PollObstructionSwitch(receiver chan<- bool) {
+	elevio.PollObstructionSwitch(receiver)
+}
@@ -0,0 +1,289 @@
+package driver
+
+import (
+	"errors"
+	"github.com/sigtot/elevio"
+	"github.com/sigtot/sanntid/utils"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const pollRate = 20 * time.Millisecond
+const ioTimeout = 500 * time.Millisecond
+const minBackoff = 100 * time.Millisecond
+const maxBackoff = 5 * time.Second
+
+const numButtonTypes = 3
+
+const moduleName = "DRIVER"
+const logString = "%-15s%s"
+
+// Commands of the elevio TCP protocol
+const (
+	cmdMotorDirection = 1
+	cmdButtonLamp     = 2
+	cmdFloorIndicator = 3
+	cmdDoorOpenLamp   = 4
+	cmdStopLamp       = 5
+	cmdGetButton      = 6
+	cmdGetFloor       = 7
+	cmdGetStop        = 8
+	cmdGetObstruction = 9
+)
+
+var errDisconnected = errors.New("not connected to elevator server")
+
+// Connection is implemented by drivers that may lose the connection to the elevator hardware.
+type Connection interface {
+	Connected() bool
+}
+
+// Connected returns false if drv has lost the connection to the elevator hardware.
+func Connected(drv Driver) bool {
+	if conn, ok := drv.(Connection); ok {
+		return conn.Connected()
+	}
+	return true
+}
+
+// TCP is a Driver for an elevator server that reconnects, with exponential backoff, when the connection is lost.
+// It remembers the motor direction and lamps last set, and re-applies them on reconnect. The Poll methods
+// send the current floor, stop button and obstruction switch values again after a reconnect.
+type TCP struct {
+	addr      string
+	numFloors int
+	mu        sync.Mutex
+	conn      net.Conn
+	lost      chan struct{}
+	gen       int // Incremented on every connect
+	log       *logrus.Logger
+
+	// Last set outputs
+	motorDir       elevio.MotorDirection
+	buttonLamps    [][numButtonTypes]bool
+	floorIndicator int
+	doorOpenLamp   bool
+	stopLamp       bool
+}
+
+// NewTCP returns a Driver for the elevator server at addr, and starts connecting to it.
+func NewTCP(addr string, numFloors int) *TCP {
+	t := &TCP{
+		addr:        addr,
+		numFloors:   numFloors,
+		lost:        make(chan struct{}, 1),
+		log:         utils.NewLogger(),
+		motorDir:    elevio.MdStop,
+		buttonLamps: make([][numButtonTypes]bool, numFloors),
+	}
+	go t.maintainConnection()
+	return t
+}
+
+// maintainConnection connects to the elevator server, and reconnects whenever the connection is lost.
+func (t *TCP) maintainConnection() {
+	backoff := minBackoff
+	for {
+		conn, err := net.DialTimeout("tcp", t.addr, ioTimeout)
+		if err != nil {
+			t.log.WithFields(logrus.Fields{
+				"addr":  t.addr,
+				"retry": backoff,
+			}).Warnf(logString, moduleName, "Could not connect to elevator server")
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		backoff = minBackoff
+
+		t.mu.Lock()
+		t.conn = conn
+		t.gen++
+		t.resync()
+		t.mu.Unlock()
+		t.log.WithField("addr", t.addr).Infof(logString, moduleName, "Connected to elevator server")
+
+		<-t.lost
+		t.log.WithField("addr", t.addr).Warnf(logString, moduleName, "Lost connection to elevator server")
+	}
+}
+
+// resync re-applies the last set outputs. Must be called with the lock held.
+func (t *TCP) resync() {
+	t.send(cmdMotorDirection, byte(int8(t.motorDir)), 0, 0)
+	for floor, lamps := range t.buttonLamps {
+		for button, value := range lamps {
+			t.send(cmdButtonLamp, byte(button), byte(floor), toByte(value))
+		}
+	}
+	t.send(cmdFloorIndicator, byte(t.floorIndicator), 0, 0)
+	t.send(cmdDoorOpenLamp, toByte(t.doorOpenLamp), 0, 0)
+	t.send(cmdStopLamp, toByte(t.stopLamp), 0, 0)
+}
+
+// send writes a message to the elevator server. Must be called with the lock held.
+func (t *TCP) send(msg ...byte) error {
+	_, err := t.request(false, msg...)
+	return err
+}
+
+// request writes a message to the elevator server, and reads the reply if expected.
+// The connection is closed on any error. Must be called with the lock held.
+func (t *TCP) request(expectReply bool, msg ...byte) ([]byte, error) {
+	if t.conn == nil {
+		return nil, errDisconnected
+	}
+	err := t.conn.SetDeadline(time.Now().Add(ioTimeout))
+	if err == nil {
+		_, err = t.conn.Write(msg)
+	}
+	reply := make([]byte, 4)
+	if err == nil && expectReply {
+		_, err = io.ReadFull(t.conn, reply)
+	}
+	if err != nil {
+		t.conn.Close()
+		t.conn = nil
+		t.lost <- struct{}{}
+		return nil, err
+	}
+	return reply, nil
+}
+
+// query sends a query to the elevator server, and returns the reply and the connection generation it was read on.
+func (t *TCP) query(msg ...byte) ([]byte, int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	reply, err := t.request(true, msg...)
+	return reply, t.gen, err
+}
+
+// Connected returns true while connected to the elevator server.
+func (t *TCP) Connected() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.conn != nil
+}
+
+func (t *TCP) SetMotorDirection(dir elevio.MotorDirection) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.motorDir = dir
+	t.send(cmdMotorDirection, byte(int8(dir)), 0, 0)
+}
+
+func (t *TCP) SetButtonLamp(button elevio.ButtonType, floor int, value bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if floor < 0 || floor >= t.numFloors || button < 0 || button >= numButtonTypes {
+		return
+	}
+	t.buttonLamps[floor][button] = value
+	t.send(cmdButtonLamp, byte(button), byte(floor), toByte(value))
+}
+
+func (t *TCP) SetFloorIndicator(floor int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.floorIndicator = floor
+	t.send(cmdFloorIndicator, byte(floor), 0, 0)
+}
+
+func (t *TCP) SetDoorOpenLamp(value bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.doorOpenLamp = value
+	t.send(cmdDoorOpenLamp, toByte(value), 0, 0)
+}
+
+func (t *TCP) SetStopLamp(value bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopLamp = value
+	t.send(cmdStopLamp, toByte(value), 0, 0)
+}
+
+// PollButtons sends a button event on receiver every time a button is pressed.
+func (t *TCP) PollButtons(receiver chan<- elevio.ButtonEvent) {
+	prev := make([][numButtonTypes]bool, t.numFloors)
+	for {
+		time.Sleep(pollRate)
+		for floor := 0; floor < t.numFloors; floor++ {
+			for button := elevio.ButtonType(0); button < numButtonTypes; button++ {
+				reply, _, err := t.query(cmdGetButton, byte(button), byte(floor), 0)
+				if err != nil {
+					continue
+				}
+				pressed := toBool(reply[1])
+				if pressed && !prev[floor][button] {
+					receiver <- elevio.ButtonEvent{Floor: floor, Button: button}
+				}
+				prev[floor][button] = pressed
+			}
+		}
+	}
+}
+
+// PollFloorSensor sends the floor on receiver every time the floor sensor changes, or -1 when leaving a floor.
+// The floor is sent again after a reconnect.
+func (t *TCP) PollFloorSensor(receiver chan<- int) {
+	prev, prevGen := -2, 0
+	for {
+		time.Sleep(pollRate)
+		reply, gen, err := t.query(cmdGetFloor, 0, 0, 0)
+		if err != nil {
+			continue
+		}
+		floor := -1
+		if toBool(reply[1]) {
+			floor = int(reply[2])
+		}
+		if floor != prev || gen != prevGen {
+			receiver <- floor
+		}
+		prev, prevGen = floor, gen
+	}
+}
+
+// PollStopButton sends the state of the stop button on receiver every time it changes, and after a reconnect.
+func (t *TCP) PollStopButton(receiver chan<- bool) {
+	t.pollBool(cmdGetStop, receiver)
+}
+
+// PollObstructionSwitch sends the state of the obstruction switch on receiver every time it changes,
+// and after a reconnect.
+func (t *TCP) PollObstructionSwitch(receiver chan<- bool) {
+	t.pollBool(cmdGetObstruction, receiver)
+}
+
+func (t *TCP) pollBool(cmd byte, receiver chan<- bool) {
+	prev, prevGen := false, 0
+	for {
+		time.Sleep(pollRate)
+		reply, gen, err := t.query(cmd, 0, 0, 0)
+		if err != nil {
+			continue
+		}
+		value := toBool(reply[1])
+		if value != prev || (gen != prevGen && prevGen != 0) {
+			receiver <- value
+		}
+		prev, prevGen = value, gen
+	}
+}
+
+func toBool(b byte) bool {
+	return b != 0
+}
+
+func toByte(value bool) byte {
+	if value {
+		return 1
+	}
+	return 0
+}
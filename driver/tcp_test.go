@@ -0,0 +1,128 @@
+package driver
+
+import (
+	"github.com/sigtot/elevio"
+	"github.com/sigtot/sanntid/simulator"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// proxy forwards connections to a simulator, and can drop them to simulate a lost connection.
+type proxy struct {
+	mu    sync.Mutex
+	simLn net.Listener
+	conns []net.Conn
+}
+
+func startProxy(t *testing.T, sim *simulator.Sim) (*proxy, string) {
+	p := &proxy{}
+	p.setSim(t, sim)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			p.mu.Lock()
+			simConn, err := net.Dial("tcp", p.simLn.Addr().String())
+			if err != nil {
+				p.mu.Unlock()
+				conn.Close()
+				continue
+			}
+			p.conns = append(p.conns, conn, simConn)
+			p.mu.Unlock()
+			go io.Copy(simConn, conn)
+			go io.Copy(conn, simConn)
+		}
+	}()
+	return p, ln.Addr().String()
+}
+
+// setSim makes new connections go to sim.
+func (p *proxy) setSim(t *testing.T, sim *simulator.Sim) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go sim.Serve(ln)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.simLn = ln
+}
+
+func (p *proxy) drop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+	p.conns = nil
+}
+
+func eventually(t *testing.T, msg string, cond func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	cfg := simulator.DefaultConfig()
+	cfg.StartPosition = 1
+	cfg.TravelTime = 10 * time.Second
+	sim := simulator.New(cfg)
+	p, addr := startProxy(t, sim)
+
+	drv := NewTCP(addr, cfg.NumFloors)
+	floors := make(chan int, 10)
+	go drv.PollFloorSensor(floors)
+	eventually(t, "Driver did not connect", drv.Connected)
+	select {
+	case floor := <-floors:
+		if floor != 1 {
+			t.Fatalf("Expected floor 1 but got %d\n", floor)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Did not receive floor")
+	}
+
+	drv.SetButtonLamp(elevio.BtnHallUp, 2, true)
+	drv.SetMotorDirection(elevio.MdUp)
+	eventually(t, "Outputs were not set", func() bool {
+		return sim.ButtonLamp(elevio.BtnHallUp, 2) && sim.MotorDirection() == elevio.MdUp
+	})
+	for len(floors) > 0 {
+		<-floors
+	}
+
+	// A fresh elevator server behind the same address should get the same outputs on reconnect
+	newSim := simulator.New(cfg)
+	p.setSim(t, newSim)
+	p.drop()
+
+	// The floor is sent again after reconnect
+	select {
+	case floor := <-floors:
+		if floor != 1 {
+			t.Fatalf("Expected floor 1 after reconnect but got %d\n", floor)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Did not receive floor after reconnect")
+	}
+	eventually(t, "Outputs were not resynced", func() bool {
+		return newSim.ButtonLamp(elevio.BtnHallUp, 2) && newSim.MotorDirection() == elevio.MdUp
+	})
+}
+
+var _ Driver = &TCP{}
@@ -0,0 +1,71 @@
+package elev
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sigtot/elevio"
+	"github.com/sigtot/sanntid/driver"
+	"github.com/sigtot/sanntid/travelmodel"
+	"time"
+)
+
+// Calibrate drives the elevator through the full shaft of numFloors floors to find the travel time between every
+// pair of adjacent floors. The elevator is first driven down to the bottom floor, and then up to the top floor, where
+// it is stopped. There the door is opened for doorOpenTime and closed again, and the time it took is the door dwell
+// of the profile. It fails if the next floor is not reached within travelTimeout.
+func Calibrate(
+	drv driver.Driver,
+	floorArrivals <-chan int,
+	numFloors int,
+	travelTimeout time.Duration,
+	doorOpenTime time.Duration) (travelmodel.Profile, error) {
+	defer drv.SetMotorDirection(elevio.MdStop)
+	if numFloors < 2 {
+		return travelmodel.Profile{}, errors.New("at least two floors are needed to calibrate")
+	}
+
+	// Find the bottom floor
+	drv.SetMotorDirection(elevio.MdDown)
+	for floor := -1; floor != 0; {
+		select {
+		case floor = <-floorArrivals:
+		case <-time.After(travelTimeout):
+			return travelmodel.Profile{}, errors.New("bottom floor not reached within travel timeout")
+		}
+	}
+	drv.SetMotorDirection(elevio.MdStop)
+	drv.SetFloorIndicator(0)
+
+	// Time every segment on the way up
+	var segments []time.Duration
+	topFloor := numFloors - 1
+	segStart := time.Now()
+	drv.SetMotorDirection(elevio.MdUp)
+	for lastFloor := 0; lastFloor < topFloor; {
+		select {
+		case floor := <-floorArrivals:
+			if floor != lastFloor+1 {
+				// Leaving a floor, or a floor already seen
+				continue
+			}
+			if floor == topFloor {
+				drv.SetMotorDirection(elevio.MdStop)
+			}
+			segments = append(segments, time.Since(segStart))
+			segStart = time.Now()
+			lastFloor = floor
+			drv.SetFloorIndicator(floor)
+		case <-time.After(travelTimeout):
+			return travelmodel.Profile{}, fmt.Errorf("floor %d not reached within travel timeout", lastFloor+1)
+		}
+	}
+
+	// Time the door
+	doorOpenedAt := time.Now()
+	drv.SetDoorOpenLamp(true)
+	time.Sleep(doorOpenTime)
+	drv.SetDoorOpenLamp(false)
+	doorDwell := time.Since(doorOpenedAt)
+
+	return travelmodel.Profile{NumFloors: numFloors, Segments: segments, DoorDwell: doorDwell}, nil
+}
@@ -0,0 +1,43 @@
+package elev
+
+import (
+	"github.com/sigtot/elevio"
+	"github.com/sigtot/sanntid/simulator"
+	"testing"
+	"time"
+)
+
+func TestCalibrate(t *testing.T) {
+	const travelTime = 200 * time.Millisecond
+	sim := simulator.New(simulator.Config{
+		NumFloors:     5,
+		TravelTime:    travelTime,
+		SensorWidth:   0.2,
+		StartPosition: 2.5,
+	})
+	floorArrivals := make(chan int)
+	go sim.PollFloorSensor(floorArrivals)
+
+	profile, err := Calibrate(sim, floorArrivals, 5, 3*travelTime, testDoorOpenTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.NumFloors != 5 || len(profile.Segments) != 4 {
+		t.Fatalf("Expected 5 floors and 4 segments but got %+v\n", profile)
+	}
+	for k, d := range profile.Segments {
+		if d < travelTime/2 || d > 2*travelTime {
+			t.Errorf("Expected segment %d to take about %s but got %s\n", k, travelTime, d)
+		}
+	}
+	if profile.DoorDwell < testDoorOpenTime || profile.DoorDwell > 2*testDoorOpenTime {
+		t.Errorf("Expected door dwell of about %s but got %s\n", testDoorOpenTime, profile.DoorDwell)
+	}
+	if sim.DoorOpen() {
+		t.Error("Expected door to be closed after calibration")
+	}
+	time.Sleep(travelTime)
+	if sim.Floor() != 4 || sim.MotorDirection() != elevio.MdStop {
+		t.Errorf("Expected elevator to be stopped at the top floor, but it is at %f\n", sim.Position())
+	}
+}
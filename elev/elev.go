@@ -1,13 +1,16 @@
 /*
 Package elev implements a simple elevator controller that directs the elevator
 to a goal floor and handles arrival at goal floor.
+The controller is a state machine, see fsm.go, whose actions are carried out on the elevator hardware.
 */
 package elev
 
 import (
 	"errors"
-	"fmt"
 	"github.com/sigtot/elevio"
+	"github.com/sigtot/sanntid/config"
+	"github.com/sigtot/sanntid/driver"
+	"github.com/sigtot/sanntid/travelmodel"
 	"github.com/sigtot/sanntid/types"
 	"github.com/sigtot/sanntid/utils"
 	"github.com/sirupsen/logrus"
@@ -15,171 +18,261 @@ import (
 	"time"
 )
 
-// Door open wait time in milliseconds
-const doorOpenWaitTime = 3000
-
-// Init timeout time in milliseconds
-const initTimeout = 3000
-
-const elevServerHost = "localhost"
-
-const numElevFloors = 4
-
 const moduleName = "ELEV"
 const logString = "%-15s%s"
 
 type elev struct {
-	dir      elevio.MotorDirection
-	pos      float64
-	goal     types.Order
-	doorOpen bool
+	drv         driver.Driver
+	model       *travelmodel.Model
+	fsm         fsm
+	measurer    measurer
+	mu          sync.Mutex
+	statePath   string
+	maintenance bool
 }
 
 // StartElevController initializes the elevator controller and starts a go-routine that
 // responds to new goals on currentGoals and announces goal arrival at goalArrival.
+// A goal at the current floor while the door is open is announced at once, and keeps the door open for another
+// door open time, unless it is a hall order in the opposite direction of travel. Then the door closes first and
+// opens again for it. If the door has closed but the elevator has not left the floor, the door is reopened.
+// The door is kept open while the obstruction switch, received on obstructions, is on. If the door is held open
+// for longer than the obstruction timeout, the elevator is unavailable until the obstruction is cleared.
+// While the stop button, received on stops, is pressed, the elevator is halted with the stop lamp lit,
+// and the doors open if it is at a floor. It resumes towards its goal when the button is released.
+// If the floor sensor does not change within the travel timeout while the motor is running, the motor or the
+// sensor has failed, and the elevator is faulty until it reaches a floor sensor again.
+// The last known floor and direction are persisted in dataDir, and used to search for a floor at startup.
+// If no floor is found, the elevator is faulty, and waits for the floor sensor instead of moving.
+// An idle elevator moves to the home floors received on parkingGoals, unless it gets a goal on the way.
+// The travel time between adjacent floors and the time the door is open at a stop are measured and recorded in model.
+// The elevator hardware is controlled through drv. If drv loses the connection to the hardware,
+// the elevator is unavailable until it reconnects.
 func StartElevController(
+	cfgStore *config.Store,
+	drv driver.Driver,
+	model *travelmodel.Model,
+	dataDir string,
 	goalArrivals chan<- types.Order,
 	currentGoals <-chan types.Order,
+	parkingGoals <-chan int,
 	floorArrivals <-chan int,
-	elevPort int,
+	obstructions <-chan bool,
+	stops <-chan bool,
 	quit <-chan int,
 	wg *sync.WaitGroup) *elev {
-	var log = logrus.New()
-	atGoal := make(chan int, 1024)
-
-	elev := elev{}
-	elevServerAddr := fmt.Sprintf("%s:%d", elevServerHost, elevPort)
-	err := elev.Init(elevServerAddr, numElevFloors, floorArrivals)
-	utils.OkOrPanic(err)
+	var log = utils.NewLogger()
 
-	log.WithFields(logrus.Fields{
-		"addr": elevServerAddr,
-	}).Infof(logString, moduleName, "Successfully initiated elev server")
+	cfg := cfgStore.Get()
+	elev := elev{drv: drv, model: model, statePath: statePath(dataDir)}
+	if err := elev.Init(cfg.Elevator.InitTimeout, floorArrivals); err != nil {
+		log.WithField("err", err).Errorf(logString, moduleName, "Could not find a floor, waiting for floor sensor")
+	} else {
+		utils.Log(log, moduleName, "Successfully initialized elevator position")
+	}
+	saved, _ := loadState(elev.statePath)
 
-	var startAgain <-chan time.Time
+	timers := make(map[timer]<-chan time.Time)
+	timerDuration := func(t timer) time.Duration {
+		cfg := cfgStore.Get()
+		return map[timer]time.Duration{
+			doorTimer:        cfg.Elevator.DoorOpenTime,
+			obstructionTimer: cfg.Elevator.ObstructionTimeout,
+			watchdogTimer:    cfg.Elevator.TravelTimeout,
+		}[t]
+	}
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
 		for {
+			var ev event
 			select {
-			case elev.goal = <-currentGoals:
-				// Set direction to deliver new goal order
-				newGoalDir, updateDir, err := goalDir(elev.goal, elev.pos)
-				utils.OkOrPanic(err)
-				if updateDir == true {
-					elev.dir = newGoalDir
-				}
-
-				if elev.atGoal() {
-					atGoal <- 1
-				} else if !elev.doorOpen {
-					elev.start()
-				}
-			case <-atGoal:
-				// Stop elevator, open doors and announce arrival
-				elev.stop()
-				elev.doorOpen = true
-				elevio.SetDoorOpenLamp(true)
-				startAgain = time.After(doorOpenWaitTime * time.Millisecond)
-				goalArrivals <- elev.goal
-				utils.Log(log, moduleName, "Opened doors")
-			case floorArrival := <-floorArrivals:
-				if floorArrival < 0 {
-					// Between floors
-					isWholeNumber := float64(int(elev.pos)) == elev.pos
-					if isWholeNumber {
-						elev.setPos(elev.pos + 0.5*float64(elev.dir))
-					}
-				} else {
-					// At floor
-					elev.setPos(float64(floorArrival))
-					if int(elev.pos) == elev.goal.Floor {
-						atGoal <- 1
-					}
-				}
-			case <-startAgain:
-				// Close doors and start elevator again
-				elevio.SetDoorOpenLamp(false)
-				elev.doorOpen = false
-				if !elev.atGoal() {
-					elev.start()
-				}
-				utils.Log(log, moduleName, "Closed doors")
+			case goal := <-currentGoals:
+				ev = goalEvent{goal}
+			case floor := <-parkingGoals:
+				ev = parkEvent{floor}
+			case floor := <-floorArrivals:
+				ev = floorEvent{floor}
+			case obstructed := <-obstructions:
+				ev = obstructionEvent{obstructed}
+			case pressed := <-stops:
+				ev = stopEvent{pressed}
+			case <-timers[doorTimer]:
+				ev = timeoutEvent{doorTimer}
+			case <-timers[obstructionTimer]:
+				ev = timeoutEvent{obstructionTimer}
+			case <-timers[watchdogTimer]:
+				ev = timeoutEvent{watchdogTimer}
 			case <-quit:
-				elevio.SetMotorDirection(elevio.MdStop)
-				elevio.SetDoorOpenLamp(false)
+				elev.drv.SetMotorDirection(elevio.MdStop)
+				elev.drv.SetDoorOpenLamp(false)
 				utils.Log(log, moduleName, "Turned off motor and closed door")
 				return
 			}
+			if t, ok := ev.(timeoutEvent); ok {
+				delete(timers, t.timer)
+			}
+
+			elev.mu.Lock()
+			newFsm, actions := elev.fsm.handle(ev)
+			if newFsm.state != elev.fsm.state {
+				log.WithFields(logrus.Fields{
+					"from": elev.fsm.state,
+					"to":   newFsm.state,
+				}).Debugf(logString, moduleName, "Changed state")
+			}
+			elev.measurer.measure(model, elev.fsm, newFsm, ev, time.Now())
+			elev.fsm = newFsm
+			elev.mu.Unlock()
+
+			if current := stateOf(newFsm); !newFsm.lost && current != saved {
+				if err := saveState(elev.statePath, current); err != nil {
+					log.WithField("err", err).Warnf(logString, moduleName, "Could not persist elevator state")
+				}
+				saved = current
+			}
+
+			for _, a := range actions {
+				switch a := a.(type) {
+				case setMotorDirection:
+					elev.drv.SetMotorDirection(a.dir)
+				case setDoorOpenLamp:
+					elev.drv.SetDoorOpenLamp(a.value)
+				case setStopLamp:
+					elev.drv.SetStopLamp(a.value)
+				case setFloorIndicator:
+					elev.drv.SetFloorIndicator(a.floor)
+				case startTimer:
+					timers[a.timer] = time.After(timerDuration(a.timer))
+				case stopTimer:
+					delete(timers, a.timer)
+				case announceArrival:
+					goalArrivals <- a.order
+				case logInfo:
+					utils.Log(log, moduleName, a.msg)
+				case logWarning:
+					log.Warnf(logString, moduleName, a.msg)
+				}
+			}
 		}
 	}()
 	return &elev
 }
 
-// Init initializes elevio and moves the elevator down to a floor in order to determine the position
-func (elev *elev) Init(addr string, numFloors int, floorArrivals <-chan int) error {
-	elevio.Init(addr, numFloors)
+// Init moves the elevator to a floor in order to determine the position. The elevator first searches in the
+// direction suggested by the persisted state for initTimeout, and then in the other direction for twice as long.
+// If no floor is found, the elevator is left faulted, with the position unknown.
+func (elev *elev) Init(initTimeout time.Duration, floorArrivals <-chan int) error {
+	defer elev.drv.SetMotorDirection(elevio.MdStop)
 
-	elevio.SetMotorDirection(elevio.MdDown)
-	elev.dir = elevio.MdDown
-
-	defer elev.stop()
-	timeout := time.After(initTimeout * time.Millisecond)
-L:
-	for {
-		select {
-		case floor := <-floorArrivals:
-			elev.setPos(float64(floor))
-			break L
-		case <-timeout:
-			return errors.New("failed to reach floor within timeout")
+	last, known := loadState(elev.statePath)
+	for i, dir := range searchDirs(last, known) {
+		elev.drv.SetMotorDirection(dir)
+		timeout := time.After(time.Duration(i+1) * initTimeout)
+	search:
+		for {
+			select {
+			case floor := <-floorArrivals:
+				if floor < 0 {
+					// Between floors, keep searching
+					continue
+				}
+				elev.mu.Lock()
+				elev.fsm = fsm{state: Idle, dir: elevio.MdStop, pos: float64(floor)}
+				elev.mu.Unlock()
+				elev.drv.SetFloorIndicator(floor)
+				return nil
+			case <-timeout:
+				break search
+			}
 		}
 	}
 
-	return nil
+	elev.mu.Lock()
+	elev.fsm = fsm{state: Faulted, dir: elevio.MdStop, pos: float64(last.Floor), lost: true}
+	elev.mu.Unlock()
+	return errors.New("failed to reach floor within timeout in either direction")
 }
 
-// goalDir calculates the new goal direction from the goal order argument and the current position
-func goalDir(goal types.Order, pos float64) (dir elevio.MotorDirection, updateDir bool, err error) {
-	if float64(goal.Floor) > pos {
-		return elevio.MdUp, true, nil
-	} else if float64(goal.Floor) < pos {
-		return elevio.MdDown, true, nil
-	} else if goal.Type == types.Hall {
-		if dir, err := utils.OrderDir2MDDir(goal.Dir); err == nil {
-			return dir, true, nil
-		}
-		return dir, false, err
-	}
-	return elevio.MdDown, false, nil
+func (elev *elev) getFsm() fsm {
+	elev.mu.Lock()
+	defer elev.mu.Unlock()
+	return elev.fsm
+}
+
+// GetState returns the current state of the elevator controller.
+func (elev *elev) GetState() State {
+	return elev.getFsm().state
 }
 
-func (elev *elev) stop() {
-	elevio.SetMotorDirection(elevio.MdStop)
+func (elev *elev) GetDir() elevio.MotorDirection {
+	return elev.getFsm().dir
 }
 
-func (elev *elev) start() {
-	elevio.SetMotorDirection(elev.dir)
+// GetPos returns the position of the elevator in floors. Between floors, the position is interpolated from the time
+// the elevator passed or departed from the last floor and the estimated travel time to the next.
+func (elev *elev) GetPos() float64 {
+	elev.mu.Lock()
+	defer elev.mu.Unlock()
+	return interpolate(elev.fsm, elev.measurer, elev.model, time.Now())
 }
 
-func (elev *elev) setPos(pos float64) {
-	elev.pos = pos
-	isWholeNumber := float64(int(elev.pos)) == elev.pos
-	if isWholeNumber {
-		elevio.SetFloorIndicator(int(pos))
-	}
+// Idle returns true while the elevator stands at a floor with the door closed and nothing to do.
+func (elev *elev) Idle() bool {
+	return elev.GetState() == Idle
 }
 
-func (elev *elev) atGoal() bool {
-	return int(2*elev.pos) == 2*elev.goal.Floor
+// DoorOpen returns true while the door is open.
+func (elev *elev) DoorOpen() bool {
+	return elev.getFsm().doorOpen
 }
 
-func (elev *elev) GetDir() elevio.MotorDirection {
-	return elev.dir
+// Available returns false while the elevator is held up by an obstruction, and should not take on hall orders.
+func (elev *elev) Available() bool {
+	return !elev.getFsm().unavailable
 }
 
-func (elev *elev) GetPos() float64 {
-	return elev.pos
+// Halted returns true while the stop button is pressed.
+func (elev *elev) Halted() bool {
+	return elev.GetState() == Stopped
+}
+
+// Faulty returns true while the motor or floor sensor is believed to have failed.
+func (elev *elev) Faulty() bool {
+	return elev.GetState() == Faulted
+}
+
+// Connected returns false while the driver has lost the connection to the elevator hardware.
+func (elev *elev) Connected() bool {
+	return driver.Connected(elev.drv)
+}
+
+// SetMaintenance takes the elevator out of service, or puts it back in service.
+// An elevator in maintenance still delivers the orders it has, but does not bid on hall calls.
+func (elev *elev) SetMaintenance(maintenance bool) {
+	elev.mu.Lock()
+	defer elev.mu.Unlock()
+	elev.maintenance = maintenance
+}
+
+// MayBid returns false while the elevator is halted, faulty, disconnected or in maintenance,
+// so that it does not buy hall orders it cannot deliver.
+func (elev *elev) MayBid() bool {
+	return elev.Status().Available()
+}
+
+// Status returns the status of the elevator.
+func (elev *elev) Status() types.ElevatorStatus {
+	state := elev.GetState()
+	elev.mu.Lock()
+	maintenance := elev.maintenance
+	elev.mu.Unlock()
+	return types.ElevatorStatus{
+		Halted:       state == Stopped,
+		Faulty:       state == Faulted,
+		Disconnected: !elev.Connected(),
+		Maintenance:  maintenance,
+	}
 }
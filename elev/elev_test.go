@@ -2,60 +2,352 @@ package elev
 
 import (
 	"github.com/sigtot/elevio"
-	"github.com/sigtot/sanntid/orders"
+	"github.com/sigtot/sanntid/config"
+	"github.com/sigtot/sanntid/travelmodel"
 	"github.com/sigtot/sanntid/types"
 	"github.com/sigtot/sanntid/utils"
+	"io/ioutil"
+	"os"
+	"sync"
 	"testing"
 	"time"
 )
 
+const testDoorOpenTime = 50 * time.Millisecond
+const testObstructionTimeout = 100 * time.Millisecond
+const testTravelTimeout = 200 * time.Millisecond
+
+// mockDriver records the motor direction, door lamp and floor indicator set by the elevator controller.
+// Floor sensor events are sent directly on the floorArrivals channel by the tests.
+type mockDriver struct {
+	motorDir       elevio.MotorDirection
+	doorOpen       bool
+	stopLamp       bool
+	floorIndicator int
+	mu             sync.Mutex
+}
+
+func (drv *mockDriver) SetMotorDirection(dir elevio.MotorDirection) {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	drv.motorDir = dir
+}
+
+func (drv *mockDriver) SetDoorOpenLamp(value bool) {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	drv.doorOpen = value
+}
+
+func (drv *mockDriver) SetFloorIndicator(floor int) {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	drv.floorIndicator = floor
+}
+
+func (drv *mockDriver) SetStopLamp(value bool) {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	drv.stopLamp = value
+}
+
+func (drv *mockDriver) SetButtonLamp(button elevio.ButtonType, floor int, value bool) {}
+func (drv *mockDriver) PollButtons(receiver chan<- elevio.ButtonEvent)                {}
+func (drv *mockDriver) PollFloorSensor(receiver chan<- int)                           {}
+func (drv *mockDriver) PollStopButton(receiver chan<- bool)                           {}
+func (drv *mockDriver) PollObstructionSwitch(receiver chan<- bool)                    {}
+
+func (drv *mockDriver) getMotorDir() elevio.MotorDirection {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	return drv.motorDir
+}
+
+func (drv *mockDriver) getDoorOpen() bool {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	return drv.doorOpen
+}
+
+type testController struct {
+	elev          *elev
+	drv           *mockDriver
+	goalArrivals  chan types.Order
+	currentGoals  chan types.Order
+	parkingGoals  chan int
+	floorArrivals chan int
+	obstructions  chan bool
+	stops         chan bool
+	quit          chan int
+}
+
+func (drv *mockDriver) getStopLamp() bool {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	return drv.stopLamp
+}
+
+// startTestController starts an elevator controller on a mock driver, initialized at floor 0.
+func startTestController() testController {
+	cfg := config.Default()
+	cfg.Elevator.DoorOpenTime = testDoorOpenTime
+	cfg.Elevator.ObstructionTimeout = testObstructionTimeout
+	cfg.Elevator.TravelTimeout = testTravelTimeout
+	tc := testController{
+		drv:           &mockDriver{},
+		goalArrivals:  make(chan types.Order),
+		currentGoals:  make(chan types.Order),
+		parkingGoals:  make(chan int),
+		floorArrivals: make(chan int),
+		obstructions:  make(chan bool),
+		stops:         make(chan bool),
+		quit:          make(chan int, 1),
+	}
+	var wg sync.WaitGroup
+
+	go func() { tc.floorArrivals <- 0 }()
+	model := travelmodel.New(cfg.NumFloors, cfg.Elevator.FloorTravelTime, cfg.Elevator.DoorOpenTime)
+	tc.elev = StartElevController(config.NewStore(cfg), tc.drv, model, "",
+		tc.goalArrivals, tc.currentGoals, tc.parkingGoals, tc.floorArrivals, tc.obstructions, tc.stops, tc.quit, &wg)
+	return tc
+}
+
+func driveThrough(floorArrivals chan int, floors ...int) {
+	for _, floor := range floors {
+		floorArrivals <- floor
+	}
+}
+
+// eventually fails the test if cond does not become true within a second.
+func eventually(t *testing.T, cond func() bool, msg string) {
+	timeout := time.After(time.Second)
+	for !cond() {
+		select {
+		case <-timeout:
+			t.Fatal(msg)
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func expectArrival(t *testing.T, goalArrivals chan types.Order, order types.Order) {
+	select {
+	case arrived := <-goalArrivals:
+		if !utils.OrdersEqual(order, arrived) {
+			t.Fatalf("Expected arrival at %+v but got %+v\n", order, arrived)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for arrival at %+v\n", order)
+	}
+}
+
 func TestInit(t *testing.T) {
-	elev := elev{}
-	err := elev.Init(elevServerHost, numElevFloors)
+	drv := &mockDriver{}
+	floorArrivals := make(chan int, 1)
+	floorArrivals <- 1
+	elev := elev{drv: drv}
+	if err := elev.Init(time.Second, floorArrivals); err != nil {
+		t.Fatal(err)
+	}
+	if elev.GetPos() != 1 || drv.floorIndicator != 1 {
+		t.Fatalf("Expected position 1 but got %f\n", elev.GetPos())
+	}
+	if drv.getMotorDir() != elevio.MdStop {
+		t.Fatal("Motor not stopped after init")
+	}
+}
+
+func TestInitBetweenFloors(t *testing.T) {
+	drv := &mockDriver{}
+	floorArrivals := make(chan int, 2)
+	floorArrivals <- -1
+	floorArrivals <- 2
+	elev := elev{drv: drv}
+	if err := elev.Init(time.Second, floorArrivals); err != nil {
+		t.Fatal(err)
+	}
+	if elev.GetPos() != 2 || drv.floorIndicator != 2 {
+		t.Fatalf("Expected position 2 but got %f\n", elev.GetPos())
+	}
+}
+
+func TestInitTimeout(t *testing.T) {
+	elev := elev{drv: &mockDriver{}}
+	if err := elev.Init(10*time.Millisecond, make(chan int)); err == nil {
+		t.Fatal("Expected init to time out when no floor is reached")
+	}
+	if !elev.Faulty() || !elev.getFsm().lost {
+		t.Fatal("Expected elevator to be faulty with unknown position after init timeout")
+	}
+}
+
+func TestInitPersistedState(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "elev")
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer os.RemoveAll(dataDir)
+
+	// Last seen moving down from floor 2, so the elevator is below it, and should search upwards first
+	if err := saveState(statePath(dataDir), persistedState{Floor: 2, Dir: elevio.MdDown}); err != nil {
+		t.Fatal(err)
+	}
+	drv := &mockDriver{}
+	floorArrivals := make(chan int)
+	elev := elev{drv: drv, statePath: statePath(dataDir)}
+	go func() {
+		for drv.getMotorDir() != elevio.MdUp {
+			time.Sleep(time.Millisecond)
+		}
+		floorArrivals <- 2
+	}()
+	if err := elev.Init(100*time.Millisecond, floorArrivals); err != nil {
+		t.Fatal(err)
+	}
+	if elev.GetPos() != 2 {
+		t.Fatalf("Expected position 2 but got %f\n", elev.GetPos())
+	}
 }
 
-func TestStartElevController(t *testing.T) {
-	goalArrivals := make(chan types.Order)
-	currentGoals := make(chan types.Order)
+func TestInitOtherDirection(t *testing.T) {
+	drv := &mockDriver{}
 	floorArrivals := make(chan int)
-	go elevio.PollFloorSensor(floorArrivals)
+	elev := elev{drv: drv}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		if drv.getMotorDir() != elevio.MdUp {
+			t.Error("Elevator did not search upwards after timing out downwards")
+		}
+		floorArrivals <- 0
+	}()
+	if err := elev.Init(10*time.Millisecond, floorArrivals); err != nil {
+		t.Fatal(err)
+	}
+}
 
-	_ = StartElevController(goalArrivals, currentGoals, floorArrivals)
+func TestStartElevController(t *testing.T) {
+	tc := startTestController()
+	drv, goalArrivals, currentGoals, floorArrivals := tc.drv, tc.goalArrivals, tc.currentGoals, tc.floorArrivals
+	defer func() { tc.quit <- 0 }()
+
+	firstOrder := types.Order{Call: types.Call{Type: types.Hall, Floor: 2, Dir: types.Down}}
+	secondOrder := types.Order{Call: types.Call{Type: types.Cab, Floor: 0, Dir: types.InvalidDir}}
 
-	firstOrder := types.Order{Call: types.Call{Type: types.Hall, Floor: 3, Dir: types.Down}}
-	secondOrder := types.Order{Call: types.Call{Type: types.Cab, Floor: 2, Dir: types.InvalidDir}}
 	currentGoals <- firstOrder
-	arrived := <-goalArrivals
-	if !utils.OrdersEqual(firstOrder, arrived) {
-		t.Fatal("Orders not equal")
-	}
+	eventually(t, func() bool { return drv.getMotorDir() == elevio.MdUp }, "Elevator not going up towards goal")
+	driveThrough(floorArrivals, -1, 1, -1, 2)
+	expectArrival(t, goalArrivals, firstOrder)
+	eventually(t, func() bool { return drv.getDoorOpen() && drv.getMotorDir() == elevio.MdStop },
+		"Elevator did not stop and open doors at goal")
+
 	currentGoals <- secondOrder
-	arrived = <-goalArrivals
-	if !utils.OrdersEqual(secondOrder, arrived) {
-		t.Fatal("Orders not equal")
-	}
+	eventually(t, func() bool { return !drv.getDoorOpen() && drv.getMotorDir() == elevio.MdDown },
+		"Elevator did not close doors and go down towards goal")
+	driveThrough(floorArrivals, -1, 1, -1, 0)
+	expectArrival(t, goalArrivals, secondOrder)
 }
 
 func TestGoalOverride(t *testing.T) {
-	goalArrivals := make(chan types.Order)
-	currentGoals := make(chan types.Order)
-	floorArrivals := make(chan int)
-	go elevio.PollFloorSensor(floorArrivals)
-
-	_ = StartElevController(goalArrivals, currentGoals, floorArrivals)
+	tc := startTestController()
+	drv, goalArrivals, currentGoals, floorArrivals := tc.drv, tc.goalArrivals, tc.currentGoals, tc.floorArrivals
+	defer func() { tc.quit <- 0 }()
 
 	firstOrder := types.Order{Call: types.Call{Type: types.Hall, Floor: 3, Dir: types.Down}}
 	secondOrder := types.Order{Call: types.Call{Type: types.Cab, Floor: 0, Dir: types.InvalidDir}}
 
 	currentGoals <- firstOrder
-	time.Sleep(2000 * time.Millisecond)
+	driveThrough(floorArrivals, -1, 1, -1)
 	currentGoals <- secondOrder
+	eventually(t, func() bool { return drv.getMotorDir() == elevio.MdDown }, "Elevator did not turn towards new goal")
+	driveThrough(floorArrivals, 1, -1, 0)
+	expectArrival(t, goalArrivals, secondOrder)
+}
 
-	arrived := <-goalArrivals
-	if !utils.OrdersEqual(secondOrder, arrived) {
-		t.Fatal("Orders not equal")
+func TestObstruction(t *testing.T) {
+	tc := startTestController()
+	defer func() { tc.quit <- 0 }()
+
+	order := types.Order{Call: types.Call{Type: types.Cab, Floor: 0, Dir: types.InvalidDir}}
+	tc.currentGoals <- order
+	expectArrival(t, tc.goalArrivals, order)
+	tc.obstructions <- true
+
+	time.Sleep(2 * testDoorOpenTime)
+	if !tc.drv.getDoorOpen() {
+		t.Fatal("Door closed while obstructed")
+	}
+	eventually(t, func() bool { return !tc.elev.Available() }, "Elevator not unavailable after long obstruction")
+
+	tc.obstructions <- false
+	eventually(t, tc.elev.Available, "Elevator not available after obstruction was cleared")
+	eventually(t, func() bool { return !tc.drv.getDoorOpen() }, "Door not closed after obstruction was cleared")
+}
+
+func TestStop(t *testing.T) {
+	tc := startTestController()
+	defer func() { tc.quit <- 0 }()
+
+	order := types.Order{Call: types.Call{Type: types.Hall, Floor: 2, Dir: types.Down}}
+	tc.currentGoals <- order
+	driveThrough(tc.floorArrivals, -1)
+
+	// Halt between floors
+	tc.stops <- true
+	eventually(t, func() bool { return tc.drv.getMotorDir() == elevio.MdStop && tc.drv.getStopLamp() },
+		"Elevator did not halt and light the stop lamp")
+	if tc.drv.getDoorOpen() {
+		t.Fatal("Door opened between floors")
+	}
+	if tc.elev.MayBid() || tc.elev.Status().Available() {
+		t.Fatal("Halted elevator is still available")
+	}
+
+	tc.stops <- false
+	eventually(t, func() bool { return tc.drv.getMotorDir() == elevio.MdUp && !tc.drv.getStopLamp() },
+		"Elevator did not resume towards goal")
+	if !tc.elev.MayBid() {
+		t.Fatal("Elevator not available after the stop button was released")
 	}
+
+	// Halt at a floor
+	driveThrough(tc.floorArrivals, 1)
+	tc.stops <- true
+	eventually(t, func() bool { return tc.drv.getMotorDir() == elevio.MdStop && tc.drv.getDoorOpen() },
+		"Elevator did not halt and open the door at floor")
+	time.Sleep(2 * testDoorOpenTime)
+	if !tc.drv.getDoorOpen() {
+		t.Fatal("Door closed while halted")
+	}
+
+	tc.stops <- false
+	eventually(t, func() bool { return !tc.drv.getDoorOpen() && tc.drv.getMotorDir() == elevio.MdUp },
+		"Elevator did not close the door and resume towards goal")
+	driveThrough(tc.floorArrivals, -1, 2)
+	expectArrival(t, tc.goalArrivals, order)
+}
+
+func TestWatchdog(t *testing.T) {
+	tc := startTestController()
+	defer func() { tc.quit <- 0 }()
+
+	order := types.Order{Call: types.Call{Type: types.Cab, Floor: 2, Dir: types.InvalidDir}}
+	tc.currentGoals <- order
+	driveThrough(tc.floorArrivals, -1)
+	time.Sleep(testTravelTimeout / 2)
+	if tc.elev.Faulty() {
+		t.Fatal("Elevator faulty before travel timeout")
+	}
+
+	eventually(t, tc.elev.Faulty, "Elevator not faulty when the floor sensor stopped changing")
+	if tc.elev.MayBid() || tc.elev.Status().Available() {
+		t.Fatal("Faulty elevator is still available")
+	}
+	if tc.drv.getMotorDir() != elevio.MdUp {
+		t.Fatal("Motor stopped when faulty")
+	}
+
+	driveThrough(tc.floorArrivals, 1)
+	eventually(t, func() bool { return !tc.elev.Faulty() }, "Elevator did not recover when reaching a floor")
+	driveThrough(tc.floorArrivals, -1, 2)
+	expectArrival(t, tc.goalArrivals, order)
 }
@@ -0,0 +1,406 @@
+package elev
+
+import (
+	"github.com/sigtot/elevio"
+	"github.com/sigtot/sanntid/types"
+	"github.com/sigtot/sanntid/utils"
+)
+
+// State is the state of the elevator controller state machine.
+type State int
+
+const (
+	Idle     State = iota // Standing at a floor with the door closed and no goal, with direction elevio.MdStop
+	Moving                // Moving towards the goal, or towards a home floor to park
+	DoorOpen              // Standing at a floor with the door open
+	Stopped               // Halted by the stop button
+	Faulted               // Moving, but the floor sensor has not changed within the travel timeout, or position unknown
+)
+
+func (s State) String() string {
+	return [...]string{"idle", "moving", "door open", "stopped", "faulted"}[s]
+}
+
+// timer identifies one of the timers of the elevator controller.
+type timer int
+
+const (
+	doorTimer        timer = iota // Closes the door after the door open time
+	obstructionTimer              // Marks the elevator unavailable when the door has been held open for too long
+	watchdogTimer                 // Marks the elevator faulted when no floor is reached within the travel timeout
+)
+
+// Events of the state machine
+type (
+	event interface{}
+
+	goalEvent struct {
+		goal types.Order
+	}
+	floorEvent struct {
+		floor int // -1 when leaving a floor
+	}
+	obstructionEvent struct {
+		obstructed bool
+	}
+	stopEvent struct {
+		pressed bool
+	}
+	parkEvent struct {
+		floor int
+	}
+	timeoutEvent struct {
+		timer timer
+	}
+)
+
+// Actions returned by the state machine, to be carried out by the elevator controller
+type (
+	action interface{}
+
+	setMotorDirection struct {
+		dir elevio.MotorDirection
+	}
+	setDoorOpenLamp struct {
+		value bool
+	}
+	setStopLamp struct {
+		value bool
+	}
+	setFloorIndicator struct {
+		floor int
+	}
+	startTimer struct {
+		timer timer
+	}
+	stopTimer struct {
+		timer timer
+	}
+	announceArrival struct {
+		order types.Order
+	}
+	logInfo struct {
+		msg string
+	}
+	logWarning struct {
+		msg string
+	}
+)
+
+// fsm holds the state of the elevator controller. Its transition functions have no side effects,
+// but return the actions needed to bring the hardware and the timers in line with the new state.
+type fsm struct {
+	state       State
+	dir         elevio.MotorDirection // Direction of travel, or elevio.MdStop when idle
+	pos         float64               // Floor number, or halfway between two floors
+	goal        types.Order
+	hasGoal     bool
+	parkFloor   int  // Home floor the elevator is parking at
+	parking     bool // Moving towards parkFloor without any goal
+	doorOpen    bool
+	doorHeld    bool // The door timer has run out while obstructed
+	obstructed  bool
+	unavailable bool // The door has been held open by an obstruction for too long
+	lost        bool // No floor was found at startup, so the position is unknown
+}
+
+// handle returns the state after ev has happened, and the actions to carry out.
+func (f fsm) handle(ev event) (fsm, []action) {
+	switch ev := ev.(type) {
+	case goalEvent:
+		return f.onGoal(ev.goal)
+	case floorEvent:
+		return f.onFloor(ev.floor)
+	case obstructionEvent:
+		return f.onObstruction(ev.obstructed)
+	case stopEvent:
+		return f.onStop(ev.pressed)
+	case parkEvent:
+		return f.onPark(ev.floor)
+	case timeoutEvent:
+		switch ev.timer {
+		case doorTimer:
+			return f.onDoorTimeout()
+		case obstructionTimer:
+			return f.onObstructionTimeout()
+		case watchdogTimer:
+			return f.onWatchdogTimeout()
+		}
+	}
+	return f, nil
+}
+
+func (f fsm) onGoal(goal types.Order) (fsm, []action) {
+	f.goal = goal
+	f.hasGoal = true
+	f.parking = false
+	if f.lost {
+		// Start towards the goal once the position is known
+		return f, nil
+	}
+	if f.doorOpen && f.atGoal() && !f.servesGoalDir() {
+		// The door closes first, and opens again to serve the goal in the new direction
+		return f, nil
+	}
+	newGoalDir, updateDir, err := goalDir(goal, f.pos)
+	utils.OkOrPanic(err)
+	if updateDir {
+		f.dir = newGoalDir
+	}
+
+	if f.atGoal() {
+		return f.arrive()
+	}
+	switch f.state {
+	case Idle:
+		f.state = Moving
+		return f, []action{setMotorDirection{f.dir}, startTimer{watchdogTimer}}
+	case Moving, Faulted:
+		// The goal may be in the opposite direction
+		return f, []action{setMotorDirection{f.dir}}
+	}
+	// The elevator starts towards the goal when the door closes, or the stop button is released
+	return f, nil
+}
+
+func (f fsm) onFloor(floor int) (fsm, []action) {
+	if f.lost {
+		return f.onFoundFloor(floor)
+	}
+
+	var actions []action
+	switch f.state {
+	case Moving:
+		actions = append(actions, startTimer{watchdogTimer})
+	case Faulted:
+		f.state = Moving
+		actions = append(actions, startTimer{watchdogTimer}, logInfo{"Floor sensor changed, elevator recovered"})
+	}
+
+	if floor < 0 {
+		// Leaving a floor
+		if f.atFloor() {
+			f.pos += 0.5 * float64(f.dir)
+		}
+		return f, actions
+	}
+	f.pos = float64(floor)
+	actions = append(actions, setFloorIndicator{floor})
+	if f.state == Moving && f.hasGoal && f.atGoal() {
+		f, arrivalActions := f.arrive()
+		return f, append(actions, arrivalActions...)
+	}
+	if f.state == Moving && f.parking && floor == f.parkFloor {
+		// Parked, the door stays closed
+		f.state = Idle
+		f.dir = elevio.MdStop
+		f.parking = false
+		return f, append(actions, setMotorDirection{elevio.MdStop}, stopTimer{watchdogTimer}, logInfo{"Parked"})
+	}
+	return f, actions
+}
+
+// onPark sends an idle elevator to a home floor. The parking move is abandoned as soon as a goal is set.
+func (f fsm) onPark(floor int) (fsm, []action) {
+	if f.state != Idle || f.hasGoal || float64(floor) == f.pos {
+		return f, nil
+	}
+	f.state = Moving
+	f.parking = true
+	f.parkFloor = floor
+	f.dir = elevio.MdUp
+	if float64(floor) < f.pos {
+		f.dir = elevio.MdDown
+	}
+	return f, []action{setMotorDirection{f.dir}, startTimer{watchdogTimer}, logInfo{"Parking at home floor"}}
+}
+
+// onFoundFloor calibrates the position of a lost elevator, and starts it towards its goal.
+func (f fsm) onFoundFloor(floor int) (fsm, []action) {
+	if floor < 0 || f.state == Stopped {
+		return f, nil
+	}
+	f.lost = false
+	f.pos = float64(floor)
+	actions := []action{setFloorIndicator{floor}, logInfo{"Found floor, elevator recovered"}}
+	if !f.hasGoal {
+		f.state = Idle
+		return f, actions
+	}
+	if newGoalDir, updateDir, err := goalDir(f.goal, f.pos); err == nil && updateDir {
+		f.dir = newGoalDir
+	}
+	var startActions []action
+	if f.atGoal() {
+		f, startActions = f.arrive()
+	} else {
+		f, startActions = f.startTowardsGoal()
+	}
+	return f, append(actions, startActions...)
+}
+
+func (f fsm) onObstruction(obstructed bool) (fsm, []action) {
+	f.obstructed = obstructed
+	if obstructed {
+		return f, []action{logInfo{"Door obstructed"}}
+	}
+
+	actions := []action{stopTimer{obstructionTimer}, logInfo{"Door obstruction cleared"}}
+	if f.unavailable {
+		f.unavailable = false
+		actions = append(actions, logInfo{"Elevator available again"})
+	}
+	if f.doorHeld {
+		f.doorHeld = false
+		actions = append(actions, startTimer{doorTimer})
+	}
+	return f, actions
+}
+
+func (f fsm) onStop(pressed bool) (fsm, []action) {
+	if pressed == (f.state == Stopped) {
+		return f, nil
+	}
+	if pressed {
+		// Halt immediately, and let passengers out if at a floor
+		f.state = Stopped
+		actions := []action{
+			setMotorDirection{elevio.MdStop},
+			stopTimer{watchdogTimer},
+			setStopLamp{true},
+			logWarning{"Stop button pressed, elevator halted"},
+		}
+		if f.atFloor() && !f.doorOpen && !f.lost {
+			f.doorOpen = true
+			actions = append(actions, setDoorOpenLamp{true})
+		}
+		return f, actions
+	}
+
+	actions := []action{setStopLamp{false}, logInfo{"Stop button released, resuming"}}
+	if f.lost {
+		f.state = Faulted
+		return f, actions
+	}
+	if f.doorOpen {
+		f.state = DoorOpen
+		f.doorHeld = false
+		return f, append(actions, startTimer{doorTimer})
+	}
+	f, startActions := f.startTowardsGoal()
+	return f, append(actions, startActions...)
+}
+
+func (f fsm) onDoorTimeout() (fsm, []action) {
+	if f.state != DoorOpen {
+		// The door is kept open until the stop button is released
+		return f, nil
+	}
+	if f.obstructed {
+		// Keep the door open until the obstruction is cleared
+		actions := []action{logInfo{"Keeping doors open due to obstruction"}}
+		if !f.doorHeld && !f.unavailable {
+			actions = append(actions, startTimer{obstructionTimer})
+		}
+		f.doorHeld = true
+		return f, actions
+	}
+
+	f.doorOpen = false
+	actions := []action{setDoorOpenLamp{false}, logInfo{"Closed doors"}}
+	f, startActions := f.startTowardsGoal()
+	return f, append(actions, startActions...)
+}
+
+func (f fsm) onObstructionTimeout() (fsm, []action) {
+	if !f.doorHeld {
+		return f, nil
+	}
+	f.unavailable = true
+	return f, []action{logWarning{"Door obstructed for too long, elevator unavailable"}}
+}
+
+func (f fsm) onWatchdogTimeout() (fsm, []action) {
+	if f.state != Moving {
+		return f, nil
+	}
+	// Keep the motor running, so that the elevator recovers if the failure is temporary
+	f.state = Faulted
+	return f, []action{logWarning{"Floor not reached within travel timeout, elevator faulty"}}
+}
+
+// arrive stops the elevator at the goal, opens the door and announces the arrival.
+// A goal at the floor where the door is already open is delivered at once, and keeps the door open for another
+// door open time. A door that has just closed is reopened, as long as the elevator has not left the floor.
+func (f fsm) arrive() (fsm, []action) {
+	if f.state != Stopped {
+		f.state = DoorOpen
+	}
+	f.hasGoal = false
+	f.doorHeld = false
+	if f.doorOpen {
+		return f, []action{startTimer{doorTimer}, announceArrival{f.goal}, logInfo{"Kept doors open"}}
+	}
+	f.doorOpen = true
+	return f, []action{
+		setMotorDirection{elevio.MdStop},
+		stopTimer{watchdogTimer},
+		setDoorOpenLamp{true},
+		startTimer{doorTimer},
+		announceArrival{f.goal},
+		logInfo{"Opened doors"},
+	}
+}
+
+// startTowardsGoal starts the elevator towards the goal if it has one, or else lets it idle.
+func (f fsm) startTowardsGoal() (fsm, []action) {
+	if f.hasGoal && f.atGoal() {
+		// A goal at this floor in the opposite direction was set while the door was open
+		if newGoalDir, updateDir, err := goalDir(f.goal, f.pos); err == nil && updateDir {
+			f.dir = newGoalDir
+		}
+		f.state = Idle
+		return f.arrive()
+	}
+	if !f.hasGoal {
+		f.state = Idle
+		f.dir = elevio.MdStop
+		f.parking = false
+		return f, nil
+	}
+	f.state = Moving
+	return f, []action{setMotorDirection{f.dir}, startTimer{watchdogTimer}}
+}
+
+func (f fsm) atFloor() bool {
+	return float64(int(f.pos)) == f.pos
+}
+
+func (f fsm) atGoal() bool {
+	return int(2*f.pos) == 2*f.goal.Floor
+}
+
+// servesGoalDir returns true if stopping for the goal lets the elevator carry on in its direction of travel,
+// i.e. the goal is a cab order, a hall order in that direction, or the elevator has no direction.
+func (f fsm) servesGoalDir() bool {
+	if f.goal.Type != types.Hall || f.dir == elevio.MdStop {
+		return true
+	}
+	orderDir, err := utils.OrderDir2MDDir(f.goal.Dir)
+	return err != nil || orderDir == f.dir
+}
+
+// goalDir calculates the new goal direction from the goal order argument and the current position
+func goalDir(goal types.Order, pos float64) (dir elevio.MotorDirection, updateDir bool, err error) {
+	if float64(goal.Floor) > pos {
+		return elevio.MdUp, true, nil
+	} else if float64(goal.Floor) < pos {
+		return elevio.MdDown, true, nil
+	} else if goal.Type == types.Hall {
+		if dir, err := utils.OrderDir2MDDir(goal.Dir); err == nil {
+			return dir, true, nil
+		}
+		return dir, false, err
+	}
+	return elevio.MdDown, false, nil
+}
@@ -0,0 +1,344 @@
+package elev
+
+import (
+	"github.com/sigtot/elevio"
+	"github.com/sigtot/sanntid/types"
+	"reflect"
+	"testing"
+)
+
+var hallDown2 = types.Order{Call: types.Call{Type: types.Hall, Floor: 2, Dir: types.Down}}
+var cab0 = types.Order{Call: types.Call{Type: types.Cab, Floor: 0, Dir: types.InvalidDir}}
+var hallUp1 = types.Order{Call: types.Call{Type: types.Hall, Floor: 1, Dir: types.Up}}
+var hallDown1 = types.Order{Call: types.Call{Type: types.Hall, Floor: 1, Dir: types.Down}}
+
+func TestTransitions(t *testing.T) {
+	cases := []struct {
+		name            string
+		before          fsm
+		ev              event
+		after           fsm
+		expectedActions []action
+	}{
+		{
+			name:            "idle starts towards new goal",
+			before:          fsm{state: Idle, dir: elevio.MdStop, pos: 0},
+			ev:              goalEvent{hallDown2},
+			after:           fsm{state: Moving, dir: elevio.MdUp, pos: 0, goal: hallDown2, hasGoal: true},
+			expectedActions: []action{setMotorDirection{elevio.MdUp}, startTimer{watchdogTimer}},
+		},
+		{
+			name:   "idle opens door for goal at current floor",
+			before: fsm{state: Idle, dir: elevio.MdStop, pos: 0},
+			ev:     goalEvent{cab0},
+			after:  fsm{state: DoorOpen, dir: elevio.MdStop, pos: 0, goal: cab0, doorOpen: true},
+			expectedActions: []action{
+				setMotorDirection{elevio.MdStop},
+				stopTimer{watchdogTimer},
+				setDoorOpenLamp{true},
+				startTimer{doorTimer},
+				announceArrival{cab0},
+				logInfo{"Opened doors"},
+			},
+		},
+		{
+			name:            "moving turns towards new goal",
+			before:          fsm{state: Moving, dir: elevio.MdUp, pos: 1.5, goal: hallDown2, hasGoal: true},
+			ev:              goalEvent{cab0},
+			after:           fsm{state: Moving, dir: elevio.MdDown, pos: 1.5, goal: cab0, hasGoal: true},
+			expectedActions: []action{setMotorDirection{elevio.MdDown}},
+		},
+		{
+			name:   "door open waits for door to close before starting",
+			before: fsm{state: DoorOpen, dir: elevio.MdDown, pos: 0, doorOpen: true},
+			ev:     goalEvent{hallDown2},
+			after: fsm{state: DoorOpen, dir: elevio.MdUp, pos: 0, goal: hallDown2, hasGoal: true,
+				doorOpen: true},
+		},
+		{
+			name:            "door open is extended for goal at current floor",
+			before:          fsm{state: DoorOpen, dir: elevio.MdStop, pos: 0, doorOpen: true},
+			ev:              goalEvent{cab0},
+			after:           fsm{state: DoorOpen, dir: elevio.MdStop, pos: 0, goal: cab0, doorOpen: true},
+			expectedActions: []action{startTimer{doorTimer}, announceArrival{cab0}, logInfo{"Kept doors open"}},
+		},
+		{
+			name:            "door open is extended for hall order at current floor in the direction of travel",
+			before:          fsm{state: DoorOpen, dir: elevio.MdUp, pos: 1, doorOpen: true},
+			ev:              goalEvent{hallUp1},
+			after:           fsm{state: DoorOpen, dir: elevio.MdUp, pos: 1, goal: hallUp1, doorOpen: true},
+			expectedActions: []action{startTimer{doorTimer}, announceArrival{hallUp1}, logInfo{"Kept doors open"}},
+		},
+		{
+			name:            "door open is not extended for hall order at current floor in the opposite direction",
+			before:          fsm{state: DoorOpen, dir: elevio.MdUp, pos: 1, doorOpen: true},
+			ev:              goalEvent{hallDown1},
+			after:           fsm{state: DoorOpen, dir: elevio.MdUp, pos: 1, goal: hallDown1, hasGoal: true, doorOpen: true},
+			expectedActions: nil,
+		},
+		{
+			name:   "door closes and reopens for hall order at current floor in the opposite direction",
+			before: fsm{state: DoorOpen, dir: elevio.MdUp, pos: 1, goal: hallDown1, hasGoal: true, doorOpen: true},
+			ev:     timeoutEvent{doorTimer},
+			after:  fsm{state: DoorOpen, dir: elevio.MdDown, pos: 1, goal: hallDown1, doorOpen: true},
+			expectedActions: []action{
+				setDoorOpenLamp{false},
+				logInfo{"Closed doors"},
+				setMotorDirection{elevio.MdStop},
+				stopTimer{watchdogTimer},
+				setDoorOpenLamp{true},
+				startTimer{doorTimer},
+				announceArrival{hallDown1},
+				logInfo{"Opened doors"},
+			},
+		},
+		{
+			name:   "closed door reopens for goal at current floor before leaving",
+			before: fsm{state: Moving, dir: elevio.MdUp, pos: 0, goal: hallDown2, hasGoal: true},
+			ev:     goalEvent{cab0},
+			after:  fsm{state: DoorOpen, dir: elevio.MdUp, pos: 0, goal: cab0, doorOpen: true},
+			expectedActions: []action{
+				setMotorDirection{elevio.MdStop},
+				stopTimer{watchdogTimer},
+				setDoorOpenLamp{true},
+				startTimer{doorTimer},
+				announceArrival{cab0},
+				logInfo{"Opened doors"},
+			},
+		},
+		{
+			name:            "leaving floor moves half a floor",
+			before:          fsm{state: Moving, dir: elevio.MdUp, pos: 1, goal: hallDown2, hasGoal: true},
+			ev:              floorEvent{-1},
+			after:           fsm{state: Moving, dir: elevio.MdUp, pos: 1.5, goal: hallDown2, hasGoal: true},
+			expectedActions: []action{startTimer{watchdogTimer}},
+		},
+		{
+			name:            "passing floor updates indicator",
+			before:          fsm{state: Moving, dir: elevio.MdUp, pos: 0.5, goal: hallDown2, hasGoal: true},
+			ev:              floorEvent{1},
+			after:           fsm{state: Moving, dir: elevio.MdUp, pos: 1, goal: hallDown2, hasGoal: true},
+			expectedActions: []action{startTimer{watchdogTimer}, setFloorIndicator{1}},
+		},
+		{
+			name:   "reaching goal floor arrives",
+			before: fsm{state: Moving, dir: elevio.MdUp, pos: 1.5, goal: hallDown2, hasGoal: true},
+			ev:     floorEvent{2},
+			after:  fsm{state: DoorOpen, dir: elevio.MdUp, pos: 2, goal: hallDown2, doorOpen: true},
+			expectedActions: []action{
+				startTimer{watchdogTimer},
+				setFloorIndicator{2},
+				setMotorDirection{elevio.MdStop},
+				stopTimer{watchdogTimer},
+				setDoorOpenLamp{true},
+				startTimer{doorTimer},
+				announceArrival{hallDown2},
+				logInfo{"Opened doors"},
+			},
+		},
+		{
+			name:   "faulted recovers on floor sensor",
+			before: fsm{state: Faulted, dir: elevio.MdUp, pos: 0.5, goal: hallDown2, hasGoal: true},
+			ev:     floorEvent{1},
+			after:  fsm{state: Moving, dir: elevio.MdUp, pos: 1, goal: hallDown2, hasGoal: true},
+			expectedActions: []action{
+				startTimer{watchdogTimer},
+				logInfo{"Floor sensor changed, elevator recovered"},
+				setFloorIndicator{1},
+			},
+		},
+		{
+			name:            "watchdog timeout faults moving elevator",
+			before:          fsm{state: Moving, dir: elevio.MdUp, pos: 0.5, goal: hallDown2, hasGoal: true},
+			ev:              timeoutEvent{watchdogTimer},
+			after:           fsm{state: Faulted, dir: elevio.MdUp, pos: 0.5, goal: hallDown2, hasGoal: true},
+			expectedActions: []action{logWarning{"Floor not reached within travel timeout, elevator faulty"}},
+		},
+		{
+			name:   "door timeout closes door and starts towards goal",
+			before: fsm{state: DoorOpen, dir: elevio.MdDown, pos: 2, goal: cab0, hasGoal: true, doorOpen: true},
+			ev:     timeoutEvent{doorTimer},
+			after:  fsm{state: Moving, dir: elevio.MdDown, pos: 2, goal: cab0, hasGoal: true},
+			expectedActions: []action{
+				setDoorOpenLamp{false},
+				logInfo{"Closed doors"},
+				setMotorDirection{elevio.MdDown},
+				startTimer{watchdogTimer},
+			},
+		},
+		{
+			name:            "door timeout without goal idles",
+			before:          fsm{state: DoorOpen, dir: elevio.MdDown, pos: 2, doorOpen: true},
+			ev:              timeoutEvent{doorTimer},
+			after:           fsm{state: Idle, dir: elevio.MdStop, pos: 2},
+			expectedActions: []action{setDoorOpenLamp{false}, logInfo{"Closed doors"}},
+		},
+		{
+			name:   "door timeout while obstructed holds door",
+			before: fsm{state: DoorOpen, pos: 2, doorOpen: true, obstructed: true},
+			ev:     timeoutEvent{doorTimer},
+			after:  fsm{state: DoorOpen, pos: 2, doorOpen: true, doorHeld: true, obstructed: true},
+			expectedActions: []action{
+				logInfo{"Keeping doors open due to obstruction"},
+				startTimer{obstructionTimer},
+			},
+		},
+		{
+			name:   "obstruction timeout makes unavailable",
+			before: fsm{state: DoorOpen, pos: 2, doorOpen: true, doorHeld: true, obstructed: true},
+			ev:     timeoutEvent{obstructionTimer},
+			after: fsm{state: DoorOpen, pos: 2, doorOpen: true, doorHeld: true, obstructed: true,
+				unavailable: true},
+			expectedActions: []action{logWarning{"Door obstructed for too long, elevator unavailable"}},
+		},
+		{
+			name: "clearing obstruction restarts door timer",
+			before: fsm{state: DoorOpen, pos: 2, doorOpen: true, doorHeld: true, obstructed: true,
+				unavailable: true},
+			ev:    obstructionEvent{false},
+			after: fsm{state: DoorOpen, pos: 2, doorOpen: true},
+			expectedActions: []action{
+				stopTimer{obstructionTimer},
+				logInfo{"Door obstruction cleared"},
+				logInfo{"Elevator available again"},
+				startTimer{doorTimer},
+			},
+		},
+		{
+			name:   "stop between floors halts",
+			before: fsm{state: Moving, dir: elevio.MdUp, pos: 1.5, goal: hallDown2, hasGoal: true},
+			ev:     stopEvent{true},
+			after:  fsm{state: Stopped, dir: elevio.MdUp, pos: 1.5, goal: hallDown2, hasGoal: true},
+			expectedActions: []action{
+				setMotorDirection{elevio.MdStop},
+				stopTimer{watchdogTimer},
+				setStopLamp{true},
+				logWarning{"Stop button pressed, elevator halted"},
+			},
+		},
+		{
+			name:   "stop at floor halts and opens door",
+			before: fsm{state: Moving, dir: elevio.MdUp, pos: 1, goal: hallDown2, hasGoal: true},
+			ev:     stopEvent{true},
+			after: fsm{state: Stopped, dir: elevio.MdUp, pos: 1, goal: hallDown2, hasGoal: true,
+				doorOpen: true},
+			expectedActions: []action{
+				setMotorDirection{elevio.MdStop},
+				stopTimer{watchdogTimer},
+				setStopLamp{true},
+				logWarning{"Stop button pressed, elevator halted"},
+				setDoorOpenLamp{true},
+			},
+		},
+		{
+			name:   "door timeout while stopped keeps door open",
+			before: fsm{state: Stopped, pos: 1, doorOpen: true},
+			ev:     timeoutEvent{doorTimer},
+			after:  fsm{state: Stopped, pos: 1, doorOpen: true},
+		},
+		{
+			name:   "release between floors resumes towards goal",
+			before: fsm{state: Stopped, dir: elevio.MdUp, pos: 1.5, goal: hallDown2, hasGoal: true},
+			ev:     stopEvent{false},
+			after:  fsm{state: Moving, dir: elevio.MdUp, pos: 1.5, goal: hallDown2, hasGoal: true},
+			expectedActions: []action{
+				setStopLamp{false},
+				logInfo{"Stop button released, resuming"},
+				setMotorDirection{elevio.MdUp},
+				startTimer{watchdogTimer},
+			},
+		},
+		{
+			name:   "release with door open restarts door timer",
+			before: fsm{state: Stopped, pos: 1, doorOpen: true},
+			ev:     stopEvent{false},
+			after:  fsm{state: DoorOpen, pos: 1, doorOpen: true},
+			expectedActions: []action{
+				setStopLamp{false},
+				logInfo{"Stop button released, resuming"},
+				startTimer{doorTimer},
+			},
+		},
+		{
+			name:   "lost elevator waits for floor before starting towards goal",
+			before: fsm{state: Faulted, dir: elevio.MdStop, lost: true},
+			ev:     goalEvent{hallDown2},
+			after:  fsm{state: Faulted, dir: elevio.MdStop, goal: hallDown2, hasGoal: true, lost: true},
+		},
+		{
+			name:   "lost elevator recovers at floor and starts towards goal",
+			before: fsm{state: Faulted, dir: elevio.MdStop, goal: hallDown2, hasGoal: true, lost: true},
+			ev:     floorEvent{1},
+			after:  fsm{state: Moving, dir: elevio.MdUp, pos: 1, goal: hallDown2, hasGoal: true},
+			expectedActions: []action{
+				setFloorIndicator{1},
+				logInfo{"Found floor, elevator recovered"},
+				setMotorDirection{elevio.MdUp},
+				startTimer{watchdogTimer},
+			},
+		},
+		{
+			name:   "lost elevator without goal idles at found floor",
+			before: fsm{state: Faulted, dir: elevio.MdStop, lost: true},
+			ev:     floorEvent{3},
+			after:  fsm{state: Idle, dir: elevio.MdStop, pos: 3},
+			expectedActions: []action{
+				setFloorIndicator{3},
+				logInfo{"Found floor, elevator recovered"},
+			},
+		},
+		{
+			name:   "idle elevator parks at home floor",
+			before: fsm{state: Idle, dir: elevio.MdStop, pos: 2},
+			ev:     parkEvent{0},
+			after:  fsm{state: Moving, dir: elevio.MdDown, pos: 2, parkFloor: 0, parking: true},
+			expectedActions: []action{
+				setMotorDirection{elevio.MdDown},
+				startTimer{watchdogTimer},
+				logInfo{"Parking at home floor"},
+			},
+		},
+		{
+			name:   "parking elevator stops at home floor with door closed",
+			before: fsm{state: Moving, dir: elevio.MdDown, pos: 0.5, parkFloor: 0, parking: true},
+			ev:     floorEvent{0},
+			after:  fsm{state: Idle, dir: elevio.MdStop, pos: 0},
+			expectedActions: []action{
+				startTimer{watchdogTimer},
+				setFloorIndicator{0},
+				setMotorDirection{elevio.MdStop},
+				stopTimer{watchdogTimer},
+				logInfo{"Parked"},
+			},
+		},
+		{
+			name:            "goal cancels parking",
+			before:          fsm{state: Moving, dir: elevio.MdDown, pos: 1.5, parkFloor: 0, parking: true},
+			ev:              goalEvent{hallDown2},
+			after:           fsm{state: Moving, dir: elevio.MdUp, pos: 1.5, goal: hallDown2, hasGoal: true},
+			expectedActions: []action{setMotorDirection{elevio.MdUp}},
+		},
+		{
+			name:   "elevator with goal does not park",
+			before: fsm{state: DoorOpen, pos: 1, goal: hallDown2, hasGoal: true, doorOpen: true},
+			ev:     parkEvent{0},
+			after:  fsm{state: DoorOpen, pos: 1, goal: hallDown2, hasGoal: true, doorOpen: true},
+		},
+		{
+			name:   "repeated stop press is ignored",
+			before: fsm{state: Stopped, pos: 1.5},
+			ev:     stopEvent{true},
+			after:  fsm{state: Stopped, pos: 1.5},
+		},
+	}
+
+	for _, c := range cases {
+		after, actions := c.before.handle(c.ev)
+		if after != c.after {
+			t.Errorf("%s: expected state %+v but got %+v\n", c.name, c.after, after)
+		}
+		if !reflect.DeepEqual(actions, c.expectedActions) {
+			t.Errorf("%s: expected actions %+v but got %+v\n", c.name, c.expectedActions, actions)
+		}
+	}
+}
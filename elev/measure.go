@@ -0,0 +1,67 @@
+package elev
+
+import (
+	"github.com/sigtot/elevio"
+	"github.com/sigtot/sanntid/travelmodel"
+	"math"
+	"time"
+)
+
+// Bounds of the interpolated fraction of the way to the next floor. The upper bound keeps the estimate short of
+// the next floor until its sensor is reached, so that the elevator is still considered able to stop there.
+const (
+	minFraction = 0.05
+	maxFraction = 0.95
+)
+
+// measurer measures the travel time between adjacent floors and the time the door is open at a stop.
+// Only travel without stops or faults in between is measured, and only door openings not caused by the stop button
+// or held open by an obstruction.
+type measurer struct {
+	segFloor     int       // Floor the current segment started at
+	segStart     time.Time // Zero if the current segment is not being measured
+	doorOpenedAt time.Time
+}
+
+// measure records the travel and door times completed by the transition from oldFsm to newFsm on ev, at time now.
+func (m *measurer) measure(model *travelmodel.Model, oldFsm fsm, newFsm fsm, ev event, now time.Time) {
+	if floorEv, ok := ev.(floorEvent); ok && floorEv.floor >= 0 && oldFsm.state == Moving && !m.segStart.IsZero() {
+		model.RecordSegment(m.segFloor, floorEv.floor, now.Sub(m.segStart))
+	}
+	if newFsm.state != Moving {
+		m.segStart = time.Time{}
+	} else if newFsm.atFloor() && (newFsm.pos != oldFsm.pos || oldFsm.state != Moving) {
+		// Passing or departing from a floor
+		m.segFloor, m.segStart = int(newFsm.pos), now
+	}
+
+	switch {
+	case newFsm.state == Stopped || newFsm.obstructed || newFsm.doorHeld:
+		m.doorOpenedAt = time.Time{}
+	case newFsm.doorOpen && !oldFsm.doorOpen:
+		m.doorOpenedAt = now
+	case !newFsm.doorOpen && oldFsm.doorOpen && !m.doorOpenedAt.IsZero():
+		model.RecordDoorDwell(now.Sub(m.doorOpenedAt))
+		m.doorOpenedAt = time.Time{}
+	}
+}
+
+// interpolate returns the position of f at time now, interpolated between floors from the time m started measuring
+// the current segment and the travel time estimated by model. Outside of a measured segment, the position of f is used.
+func interpolate(f fsm, m measurer, model *travelmodel.Model, now time.Time) float64 {
+	if f.state != Moving || f.atFloor() || m.segStart.IsZero() || f.dir == elevio.MdStop {
+		return f.pos
+	}
+	from := float64(m.segFloor)
+	to := from + float64(f.dir)
+	if math.Abs(f.pos-from) != 0.5 || math.Abs(f.pos-to) != 0.5 {
+		// The elevator has turned since the segment started
+		return f.pos
+	}
+	segTime := model.TravelTime(from, to)
+	if segTime <= 0 {
+		return f.pos
+	}
+	fraction := math.Max(minFraction, math.Min(maxFraction, float64(now.Sub(m.segStart))/float64(segTime)))
+	return from + float64(f.dir)*fraction
+}
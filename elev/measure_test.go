@@ -0,0 +1,101 @@
+package elev
+
+import (
+	"github.com/sigtot/elevio"
+	"github.com/sigtot/sanntid/travelmodel"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMeasure(t *testing.T) {
+	model := travelmodel.New(4, 2*time.Second, 2*time.Second)
+	var m measurer
+	start := time.Now()
+
+	// Run the elevator from floor 0 to floor 2 in 1.5s per floor, and hold the door open for 1s
+	f := fsm{state: Idle, dir: elevio.MdStop, pos: 0}
+	steps := []struct {
+		ev    event
+		after time.Duration
+	}{
+		{goalEvent{hallDown2}, 0},
+		{floorEvent{-1}, 200 * time.Millisecond},
+		{floorEvent{1}, 1500 * time.Millisecond},
+		{floorEvent{-1}, 1700 * time.Millisecond},
+		{floorEvent{2}, 3000 * time.Millisecond},
+		{timeoutEvent{doorTimer}, 4000 * time.Millisecond},
+	}
+	for _, step := range steps {
+		newFsm, _ := f.handle(step.ev)
+		m.measure(model, f, newFsm, step.ev, start.Add(step.after))
+		f = newFsm
+	}
+
+	segments := model.Segments()
+	expected := []time.Duration{1850 * time.Millisecond, 1850 * time.Millisecond, 2 * time.Second}
+	for k := range segments {
+		if segments[k] != expected[k] {
+			t.Errorf("Expected segment %d estimate %s but got %s\n", k, expected[k], segments[k])
+		}
+	}
+	if d := model.DoorDwell(); d != 1700*time.Millisecond {
+		t.Errorf("Expected door dwell estimate %s but got %s\n", 1700*time.Millisecond, d)
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	model := travelmodel.New(4, 2*time.Second, 2*time.Second)
+	start := time.Now()
+	m := measurer{segFloor: 1, segStart: start}
+	between := fsm{state: Moving, dir: elevio.MdUp, pos: 1.5, goal: hallDown2, hasGoal: true}
+	cases := []struct {
+		name     string
+		f        fsm
+		after    time.Duration
+		expected float64
+	}{
+		{"halfway", between, time.Second, 1.5},
+		{"just left", between, 0, 1 + minFraction},
+		{"late is kept short of next floor", between, 3 * time.Second, 1 + maxFraction},
+		{"quarter way down", fsm{state: Moving, dir: elevio.MdDown, pos: 0.5}, 500 * time.Millisecond, 0.75},
+		{"at floor", fsm{state: Moving, dir: elevio.MdUp, pos: 1}, time.Second, 1},
+		{"turned", fsm{state: Moving, dir: elevio.MdDown, pos: 1.5}, time.Second, 1.5},
+		{"stopped", fsm{state: Stopped, dir: elevio.MdUp, pos: 1.5}, time.Second, 1.5},
+	}
+	for _, c := range cases {
+		if pos := interpolate(c.f, m, model, start.Add(c.after)); math.Abs(pos-c.expected) > 1e-9 {
+			t.Errorf("%s: expected position %.2f but got %.2f\n", c.name, c.expected, pos)
+		}
+	}
+}
+
+func TestMeasureIgnoresObstructedDoor(t *testing.T) {
+	model := travelmodel.New(4, 2*time.Second, 2*time.Second)
+	var m measurer
+	start := time.Now()
+
+	// Open the door at floor 0, and hold it open with the obstruction switch for a minute
+	f := fsm{state: Idle, dir: elevio.MdStop, pos: 0}
+	steps := []struct {
+		ev    event
+		after time.Duration
+	}{
+		{goalEvent{cab0}, 0},
+		{obstructionEvent{true}, time.Second},
+		{timeoutEvent{doorTimer}, 3 * time.Second},
+		{obstructionEvent{false}, 60 * time.Second},
+		{timeoutEvent{doorTimer}, 63 * time.Second},
+	}
+	for _, step := range steps {
+		newFsm, _ := f.handle(step.ev)
+		m.measure(model, f, newFsm, step.ev, start.Add(step.after))
+		f = newFsm
+	}
+	if f.doorOpen {
+		t.Fatal("Expected door to be closed")
+	}
+	if d := model.DoorDwell(); d != 2*time.Second {
+		t.Errorf("Expected door dwell estimate to be unchanged by obstruction but got %s\n", d)
+	}
+}
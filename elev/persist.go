@@ -0,0 +1,71 @@
+package elev
+
+import (
+	"encoding/json"
+	"github.com/sigtot/elevio"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+)
+
+const stateFileName = "elevator_state.json"
+const stateFilePerms = 0600
+
+// persistedState is the last floor the elevator was known to be at, and its direction of travel from there.
+// It is used to guess which direction to search for a floor in at startup.
+type persistedState struct {
+	Floor int
+	Dir   elevio.MotorDirection
+}
+
+// stateOf returns the state to persist for f. Between floors, the floor is the one the elevator left.
+func stateOf(f fsm) persistedState {
+	if f.dir == elevio.MdDown {
+		return persistedState{Floor: int(math.Ceil(f.pos)), Dir: f.dir}
+	}
+	return persistedState{Floor: int(f.pos), Dir: f.dir}
+}
+
+// statePath returns the path of the state file in dataDir, or an empty path if there is no data directory.
+func statePath(dataDir string) string {
+	if dataDir == "" {
+		return ""
+	}
+	return filepath.Join(dataDir, stateFileName)
+}
+
+// loadState returns the state persisted at path, and false if there is none.
+func loadState(path string) (persistedState, bool) {
+	var state persistedState
+	if path == "" {
+		return state, false
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return state, false
+	}
+	if err := json.Unmarshal(buf, &state); err != nil {
+		return state, false
+	}
+	return state, true
+}
+
+func saveState(path string, state persistedState) error {
+	if path == "" {
+		return nil
+	}
+	js, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, js, stateFilePerms)
+}
+
+// searchDirs returns the directions to search for a floor in at startup, in order of preference.
+// An elevator last seen moving up from a floor is likely above it, and should thus search downwards first.
+func searchDirs(last persistedState, known bool) [2]elevio.MotorDirection {
+	if known && last.Dir == elevio.MdDown {
+		return [2]elevio.MotorDirection{elevio.MdUp, elevio.MdDown}
+	}
+	return [2]elevio.MotorDirection{elevio.MdDown, elevio.MdUp}
+}
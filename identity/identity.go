@@ -0,0 +1,57 @@
+/*
+Package identity determines the ID an elevator uses on the network. The ID is used to route cab calls to the
+elevator that received them, and to tell the elevator's own messages apart from those of other elevators.
+*/
+package identity
+
+import (
+	"crypto/rand"
+	"fmt"
+	"github.com/sigtot/sanntid/mac"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const idFileName = "elevator_id"
+const idFilePerms = 0600
+
+// GetElevatorID returns the ID of this elevator.
+// An explicitly given id takes precedence. Otherwise the UUID persisted in dataDir is used, and if there is none,
+// a new one is generated and persisted. The mac address of a non-loopback interface is used as a last resort
+// if the UUID cannot be persisted.
+func GetElevatorID(id string, dataDir string) (string, error) {
+	if id != "" {
+		return id, nil
+	}
+
+	idFile := filepath.Join(dataDir, idFileName)
+	if buf, err := ioutil.ReadFile(idFile); err == nil {
+		if persistedID := strings.TrimSpace(string(buf)); persistedID != "" {
+			return persistedID, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return mac.GetMacAddr()
+	}
+
+	newID, err := newUUID()
+	if err != nil {
+		return mac.GetMacAddr()
+	}
+	if err := ioutil.WriteFile(idFile, []byte(newID+"\n"), idFilePerms); err != nil {
+		return mac.GetMacAddr()
+	}
+	return newID, nil
+}
+
+// newUUID generates a random (version 4) UUID.
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // Version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // Variant 10
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -0,0 +1,52 @@
+package identity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetElevatorID(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	// The flag takes precedence and is not persisted
+	id, err := GetElevatorID("elev1", dataDir)
+	if err != nil || id != "elev1" {
+		t.Fatalf("Expected id elev1 but got %s (err: %v)\n", id, err)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, idFileName)); !os.IsNotExist(err) {
+		t.Fatal("Explicit id should not be persisted")
+	}
+
+	// A generated id is persisted and reused
+	first, err := GetElevatorID("", dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := GetElevatorID("", dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Fatalf("Persisted id changed from %s to %s\n", first, second)
+	}
+
+	// Separate data dirs get separate ids
+	otherDataDir, err := ioutil.TempDir("", "identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(otherDataDir)
+	other, err := GetElevatorID("", otherDataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == first {
+		t.Fatalf("Two data dirs got the same id %s\n", first)
+	}
+}
@@ -7,29 +7,38 @@ package indicators
 import (
 	"encoding/json"
 	"github.com/sigtot/elevio"
-	"github.com/sigtot/sanntid/mac"
+	"github.com/sigtot/sanntid/config"
+	"github.com/sigtot/sanntid/driver"
 	"github.com/sigtot/sanntid/pubsub"
 	"github.com/sigtot/sanntid/types"
 	"github.com/sigtot/sanntid/utils"
-	"github.com/sirupsen/logrus"
 	"sync"
 )
 
-const numFloors = 4 // TODO: Move this maybe
-const topFloor = numFloors - 1
 const bottomFloor = 0
 const moduleName = "ORDER IND"
 
 // StartIndicatorHandler starts a go-routine that initializes the indicators, and listens for call sales and
 // order deliveries on the network, updating the order indicators accordingly.
 // An indicator handler subscribes to sale acknowledgements and order deliveries.
-func StartIndicatorHandler(quit <-chan int, wg *sync.WaitGroup) {
-	ackSubChan, _ := pubsub.StartSubscriber(pubsub.AckDiscoveryPort, pubsub.AckTopic)
-	orderDeliveredSubChan, _ := pubsub.StartSubscriber(pubsub.OrderDeliveredDiscoveryPort, pubsub.OrderDeliveredTopic)
-	allOff()
-	macAddr, err := mac.GetMacAddr()
-	utils.OkOrPanic(err)
-	log := logrus.New()
+// Only cab calls belonging to elevatorID are shown. The lamps are set through drv.
+// The lamps of the restored orders, left in the queue by an earlier run, are lit at startup.
+func StartIndicatorHandler(
+	cfg config.Config,
+	drv driver.Driver,
+	elevatorID string,
+	restored []types.Order,
+	quit <-chan int,
+	wg *sync.WaitGroup) {
+	ports := pubsub.GetDiscoveryPorts(cfg.Network.DiscoveryBasePort)
+	ackSubChan, _ := pubsub.StartSubscriber(ports.Ack, pubsub.AckTopic)
+	orderDeliveredSubChan, _ := pubsub.StartSubscriber(ports.OrderDelivered, pubsub.OrderDeliveredTopic)
+	topFloor := cfg.TopFloor()
+	allOff(drv, topFloor)
+	for _, order := range restored {
+		setLamp(drv, topFloor, elevatorID, order.Call, order.ElevatorID, true)
+	}
+	log := utils.NewLogger()
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -40,23 +49,16 @@ func StartIndicatorHandler(quit <-chan int, wg *sync.WaitGroup) {
 				ack := types.Ack{}
 				err := json.Unmarshal(ackJson, &ack)
 				utils.OkOrPanic(err)
-
-				withinRange := ack.Call.Floor <= topFloor || ack.Call.Floor >= bottomFloor
-				if withinRange && (ack.Call.Type == types.Hall || ack.ElevatorID == macAddr) {
-					elevio.SetButtonLamp(getBtnType(ack.Call.Type, ack.Call.Dir), ack.Call.Floor, true)
-				}
+				setLamp(drv, topFloor, elevatorID, ack.Call, ack.ElevatorID, true)
 
 			case orderJson := <-orderDeliveredSubChan:
 				utils.Log(log, moduleName, "Got order delivered")
 				order := types.Order{}
 				err := json.Unmarshal(orderJson, &order)
 				utils.OkOrPanic(err)
-				withinRange := order.Floor <= topFloor || order.Floor >= bottomFloor
-				if withinRange && (order.Type == types.Hall || order.ElevatorID == macAddr) {
-					elevio.SetButtonLamp(getBtnType(order.Type, order.Dir), order.Floor, false)
-				}
+				setLamp(drv, topFloor, elevatorID, order.Call, order.ElevatorID, false)
 			case <-quit:
-				allOff()
+				allOff(drv, topFloor)
 				utils.Log(log, moduleName, "Turned off all order indicators")
 				return
 			}
@@ -64,6 +66,14 @@ func StartIndicatorHandler(quit <-chan int, wg *sync.WaitGroup) {
 	}()
 }
 
+// setLamp sets the lamp of call to value, if call is within range and is a hall call or owned by elevatorID.
+func setLamp(drv driver.Driver, topFloor int, elevatorID string, call types.Call, ownerID string, value bool) {
+	withinRange := call.Floor <= topFloor && call.Floor >= bottomFloor
+	if withinRange && (call.Type == types.Hall || ownerID == elevatorID) {
+		drv.SetButtonLamp(getBtnType(call.Type, call.Dir), call.Floor, value)
+	}
+}
+
 // getBtnType translates a call type and a call direction to an elevio button type.
 func getBtnType(callType types.CallType, dir types.Direction) elevio.ButtonType {
 	if callType == types.Hall {
@@ -75,15 +85,15 @@ func getBtnType(callType types.CallType, dir types.Direction) elevio.ButtonType
 	return elevio.BtnCab
 }
 
-// allOff turns off all order indicators.
-func allOff() {
+// allOff turns off all order indicators up to and including topFloor.
+func allOff(drv driver.Driver, topFloor int) {
 	for i := bottomFloor; i <= topFloor; i++ {
-		elevio.SetButtonLamp(elevio.BtnCab, i, false)
+		drv.SetButtonLamp(elevio.BtnCab, i, false)
 		if i != bottomFloor {
-			elevio.SetButtonLamp(elevio.BtnHallDown, i, false)
+			drv.SetButtonLamp(elevio.BtnHallDown, i, false)
 		}
 		if i != topFloor {
-			elevio.SetButtonLamp(elevio.BtnHallUp, i, false)
+			drv.SetButtonLamp(elevio.BtnHallUp, i, false)
 		}
 	}
 }
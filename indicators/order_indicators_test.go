@@ -3,7 +3,8 @@ package indicators
 import (
 	"encoding/json"
 	"fmt"
-	"github.com/sigtot/elevio"
+	"github.com/sigtot/sanntid/config"
+	"github.com/sigtot/sanntid/driver"
 	"github.com/sigtot/sanntid/pubsub"
 	"github.com/sigtot/sanntid/types"
 	"log"
@@ -14,10 +15,10 @@ import (
 
 // This test cannot fail. Just watch the lights :)
 func TestStartHandlingIndicators(t *testing.T) {
-	elevio.Init("localhost:15657", 4)
+	drv := driver.NewElevio("localhost:15657", 4)
 	var wg sync.WaitGroup
 	quit := make(chan int)
-	StartIndicatorHandler(quit, &wg)
+	StartIndicatorHandler(config.Default(), drv, "", nil, quit, &wg)
 	ackPubChan := pubsub.StartPublisher(pubsub.AckDiscoveryPort)
 	orderDeliveredPubChan := pubsub.StartPublisher(pubsub.OrderDeliveredDiscoveryPort)
 	call := types.Call{Type: types.Cab, Floor: 2, Dir: types.InvalidDir, ElevatorID: ""}
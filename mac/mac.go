@@ -1,9 +1,11 @@
 /*
-Package mac contains the functionality for finding the eno1, eth1 or eth0 mac-addresses on linux-ubuntu machines.
+Package mac contains the functionality for finding a mac-address of the machine. The eno1, eth1 or eth0 interfaces
+are preferred, but any other non-loopback interface with a hardware address is used if none of them exist.
 */
 package mac
 
 import (
+	"errors"
 	"net"
 )
 
@@ -14,12 +16,23 @@ func GetMacAddr() (string, error) {
 	err := error(nil)
 	for _, interfaceName := range interfaceNames {
 		mac, newErr := net.InterfaceByName(interfaceName)
-		if newErr == nil {
+		if newErr == nil && len(mac.HardwareAddr) > 0 {
 			return mac.HardwareAddr.String(), nil
 		}
 		err = mergeErrors(err, newErr)
 	}
-	return "", err
+
+	// Fall back to any non-loopback interface, e.g. enp3s0 or wlan0
+	interfaces, newErr := net.Interfaces()
+	if newErr != nil {
+		return "", mergeErrors(err, newErr)
+	}
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagLoopback == 0 && len(iface.HardwareAddr) > 0 {
+			return iface.HardwareAddr.String(), nil
+		}
+	}
+	return "", errors.New("no non-loopback interface with a mac address found")
 }
 
 func mergeErrors(oldErr error, newErr error) error {
@@ -2,14 +2,23 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"github.com/sigtot/elevio"
 	"github.com/sigtot/sanntid/buttons"
 	"github.com/sigtot/sanntid/buyer"
+	"github.com/sigtot/sanntid/config"
+	"github.com/sigtot/sanntid/configsync"
+	"github.com/sigtot/sanntid/driver"
 	"github.com/sigtot/sanntid/elev"
+	"github.com/sigtot/sanntid/identity"
 	"github.com/sigtot/sanntid/indicators"
 	"github.com/sigtot/sanntid/orders"
 	"github.com/sigtot/sanntid/orderwatcher"
+	"github.com/sigtot/sanntid/parking"
+	"github.com/sigtot/sanntid/pubsub"
 	"github.com/sigtot/sanntid/seller"
+	"github.com/sigtot/sanntid/telemetry"
+	"github.com/sigtot/sanntid/travelmodel"
 	"github.com/sigtot/sanntid/types"
 	"github.com/sigtot/sanntid/utils"
 	"github.com/sirupsen/logrus"
@@ -17,72 +26,304 @@ import (
 	"math/rand"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"sync"
+	"syscall"
 	"time"
 )
 
 const dbName = "orderwatcher.db"
 const dbPerms = 0600
 const dbTimeout = 300
+const queueDbName = "queue.db"
 
 const moduleName = "MAIN"
+const logString = "%-15s%s"
 
-const defaultElevPort = 15657
+const elevServerHost = "localhost"
+
+const defaultDataDir = "."
+const dataDirPerms = 0700
+
+const drainPollInterval = 100 * time.Millisecond
 
 func main() {
 	rand.Seed(time.Now().UnixNano())
 
-	// Read elevator server port flag
-	var elevPort = flag.Int("port", defaultElevPort, "port for connecting to the elevator server")
+	// Read config file, elevator id and data directory flags, as well as flags overriding the config file
+	var configPath = flag.String("config", "", "path to YAML config file (default is the built-in config)")
+	var idFlag = flag.String("id", "", "elevator id (default is a UUID persisted in the data directory)")
+	var dataDir = flag.String("data-dir", defaultDataDir, "directory for databases and persisted state")
+	var calibrate = flag.Bool("calibrate", false, "drive the full shaft to measure the building, save the profile and exit")
+	configFlags := config.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
-	log := logrus.New()
+	log := utils.NewLogger()
 	utils.Log(log, moduleName, "Starting elevator")
 
+	cfg, err := config.Load(*configPath, configFlags)
+	if err != nil {
+		log.WithField("err", err).Fatalf(logString, moduleName, "Invalid config")
+	}
+	applyGlobalConfig(cfg)
+
+	err = os.MkdirAll(*dataDir, dataDirPerms)
+	utils.OkOrPanic(err)
+
+	elevServerAddr := fmt.Sprintf("%s:%d", elevServerHost, cfg.Elevator.ServerPort)
+	profilePath := travelmodel.ProfilePath(*dataDir)
+	if *calibrate {
+		runCalibration(log, cfg, elevServerAddr, profilePath)
+		return
+	}
+	profile, hasProfile := travelmodel.LoadProfile(profilePath)
+	if hasProfile {
+		cfg.NumFloors = profile.NumFloors
+		log.WithField("floors", profile.NumFloors).Infof(logString, moduleName, "Loaded building profile")
+		if err := cfg.Validate(); err != nil {
+			log.WithField("err", err).Fatalf(logString, moduleName, "Config does not fit building profile")
+		}
+	}
+
+	elevatorID, err := identity.GetElevatorID(*idFlag, *dataDir)
+	utils.OkOrPanic(err)
+	log.WithField("id", elevatorID).Infof(logString, moduleName, "Got elevator id")
+
+	cfgStore := config.NewStore(cfg)
+	configSync := configsync.StartConfigSync(cfgStore, elevatorID)
+
+	drv := driver.NewTCP(elevServerAddr, cfg.NumFloors)
+	log.WithField("addr", elevServerAddr).Infof(logString, moduleName, "Connecting to elevator server")
+
 	var wg sync.WaitGroup
 
 	goalArrivals := make(chan types.Order)
 	currentGoals := make(chan types.Order)
+	parkingGoals := make(chan int)
 	floorArrivals := make(chan int)
 	quitElev := make(chan int)
-	go elevio.PollFloorSensor(floorArrivals)
-	elevator := elev.StartElevController(goalArrivals, currentGoals, floorArrivals, *elevPort, quitElev, &wg)
+	obstructions := make(chan bool)
+	stops := make(chan bool)
+	go drv.PollFloorSensor(floorArrivals)
+	go drv.PollObstructionSwitch(obstructions)
+	go drv.PollStopButton(stops)
+	model := travelmodel.New(cfg.NumFloors, cfg.Elevator.FloorTravelTime, cfg.Elevator.DoorOpenTime)
+	if hasProfile {
+		model = travelmodel.NewFromProfile(profile)
+	}
+	elevator := elev.StartElevController(
+		cfgStore, drv, model, *dataDir,
+		goalArrivals, currentGoals, parkingGoals, floorArrivals, obstructions, stops, quitElev, &wg)
 
 	callsForSale := make(chan types.Call)
 	buttonEvents := make(chan elevio.ButtonEvent)
-	go elevio.PollButtons(buttonEvents)
-	buttons.StartButtonHandler(buttonEvents, callsForSale)
+	go drv.PollButtons(buttonEvents)
+	buttons.StartButtonHandler(buttonEvents, callsForSale, elevatorID)
 
-	quitIndicators := make(chan int)
-	indicators.StartIndicatorHandler(quitIndicators, &wg)
-
-	oh, newOrders := orders.StartOrderHandler(currentGoals, goalArrivals, elevator)
-
-	buyer.StartBuying(oh, newOrders)
+	queueDb, err := bolt.Open(filepath.Join(*dataDir, queueDbName), dbPerms, &bolt.Options{Timeout: dbTimeout * time.Millisecond})
+	utils.OkOrPanic(err)
+	oh := orders.StartOrderHandler(cfgStore, queueDb, currentGoals, goalArrivals, elevator, model)
 
-	seller.StartSelling(callsForSale)
+	seller.StartSelling(cfgStore, callsForSale)
 
-	orderWatcherDb, err := bolt.Open(dbName, dbPerms, &bolt.Options{Timeout: dbTimeout * time.Millisecond})
+	dbPath := filepath.Join(*dataDir, dbName)
+	orderWatcherDb, err := bolt.Open(dbPath, dbPerms, &bolt.Options{Timeout: dbTimeout * time.Millisecond})
 	utils.OkOrPanic(err)
 	quitOrderWatcher := make(chan int)
-	orderwatcher.StartOrderWatcher(callsForSale, orderWatcherDb, quitOrderWatcher, &wg)
+	orderWatcher := orderwatcher.StartOrderWatcher(
+		cfgStore, callsForSale, orderWatcherDb, *dataDir, elevatorID, quitOrderWatcher, &wg)
+
+	restoreCabOrders(log, orderWatcher, oh)
+
+	quitIndicators := make(chan int)
+	indicators.StartIndicatorHandler(cfg, drv, elevatorID, oh.Snapshot().Orders, quitIndicators, &wg)
+
+	buyer.StartBuying(cfg, oh, oh, elevatorID, configSync, elevator)
+	telemetry.StartPublishing(cfgStore, elevatorID, elevator, oh)
+	parking.StartParking(cfgStore, elevatorID, elevator, oh, parkingGoals)
+
+	go handOverWhenHeldUp(log, elevator, oh, callsForSale)
 
 	quitDistributor := make(chan int)
-	orderwatcher.StartDbDistributor(orderWatcherDb, dbName, quitDistributor)
+	orderwatcher.StartDbDistributor(cfg, orderWatcherDb, dbPath, elevatorID, quitDistributor)
 
 	utils.Log(log, moduleName, "Successfully initialized all modules")
 
+	sigHup := make(chan os.Signal, 1)
+	signal.Notify(sigHup, syscall.SIGHUP)
+	sigUsr1 := make(chan os.Signal, 1)
+	signal.Notify(sigUsr1, syscall.SIGUSR1)
 	sigInt := make(chan os.Signal, 1)
 	signal.Notify(sigInt, os.Interrupt)
-	<-sigInt
+	maintenance := false
+L:
+	for {
+		select {
+		case <-sigHup:
+			reloadConfig(log, cfgStore, *configPath, configFlags, profile, hasProfile)
+		case <-sigUsr1:
+			maintenance = !maintenance
+			setMaintenance(log, elevator, oh, callsForSale, maintenance)
+		case <-sigInt:
+			break L
+		}
+	}
 	signal.Stop(sigInt) // Stop trapping interrupt signal to give it back its usual behavior
 
+	utils.Log(log, moduleName, "Draining orders before stopping. Do ^C again to force")
+	setMaintenance(log, elevator, oh, callsForSale, true)
+	drain(log, oh, cfgStore.Get().Elevator.DrainTimeout)
+
 	utils.Log(log, moduleName, "Gracefully stopping all modules. Do ^C again to force")
 	quitElev <- 0
 	quitIndicators <- 0
 	quitOrderWatcher <- 0
 	err = orderWatcherDb.Close()
 	utils.OkOrPanic(err)
+	err = queueDb.Close()
+	utils.OkOrPanic(err)
 	wg.Wait()
 	utils.Log(log, moduleName, "Stopped elevator")
 }
+
+// restoreCabOrders asks the peers for the cab orders of this elevator, and adds them directly to oh, without an
+// auction. This recovers the cab orders of a node that restarts with an empty disk. The peers are only asked if the
+// queue restored from disk is empty. The restored orders are announced, so that the peers do not resell them.
+func restoreCabOrders(log *logrus.Logger, ow *orderwatcher.OrderWatcher, oh *orders.OrderHandler) {
+	if len(oh.Snapshot().Orders) > 0 {
+		utils.Log(log, moduleName, "Queue restored from disk, not asking peers for cab orders")
+		return
+	}
+	restored := ow.RestoreCabOrders()
+	for _, order := range restored {
+		utils.LogOrder(log, moduleName, "Restored cab order from peers", order)
+		oh.AddOrder(order)
+	}
+	ow.AnnounceOrders(restored)
+}
+
+// heldUpPollInterval is how often handOverWhenHeldUp checks whether the elevator is held up
+const heldUpPollInterval = 50 * time.Millisecond
+
+// handOverWhenHeldUp puts the hall orders of the elevator up for sale when it is halted by the stop button,
+// or its motor or floor sensor fails, so that other elevators deliver them. Only this node sells them, as it is the
+// one that knows the orders are released from its queue.
+func handOverWhenHeldUp(
+	log *logrus.Logger,
+	elevator interface {
+		Halted() bool
+		Faulty() bool
+	},
+	oh *orders.OrderHandler,
+	callsForSale chan<- types.Call) {
+	heldUp := false
+	for range time.Tick(heldUpPollInterval) {
+		wasHeldUp := heldUp
+		heldUp = elevator.Halted() || elevator.Faulty()
+		if !heldUp || wasHeldUp {
+			continue
+		}
+		released := oh.ReleaseHallOrders()
+		log.WithField("released", len(released)).Infof(logString, moduleName, "Elevator held up, handing over orders")
+		for _, order := range released {
+			callsForSale <- order.Call
+		}
+	}
+}
+
+// setMaintenance takes the elevator out of service, or puts it back in service. An elevator taken out of service
+// stops bidding on hall calls and puts its hall orders up for sale.
+func setMaintenance(
+	log *logrus.Logger,
+	elevator interface{ SetMaintenance(bool) },
+	oh *orders.OrderHandler,
+	callsForSale chan<- types.Call,
+	maintenance bool) {
+	elevator.SetMaintenance(maintenance)
+	if !maintenance {
+		utils.Log(log, moduleName, "Elevator back in service")
+		return
+	}
+	released := oh.ReleaseHallOrders()
+	log.WithField("released", len(released)).Infof(logString, moduleName, "Elevator taken out of service")
+	for _, order := range released {
+		callsForSale <- order.Call
+	}
+}
+
+// drain waits for the elevator to deliver the orders left in its queue, for at most drainTimeout.
+// It waits for at least one poll interval before checking the queue.
+// Cab orders not delivered in time are kept in the order watcher db.
+func drain(log *logrus.Logger, oh *orders.OrderHandler, drainTimeout time.Duration) {
+	timeout := time.After(drainTimeout)
+	for {
+		select {
+		case <-timeout:
+			log.WithField("left", oh.QueueLength()).Warnf(logString, moduleName, "Could not deliver all orders in time")
+			return
+		case <-time.After(drainPollInterval):
+		}
+		if oh.QueueLength() == 0 {
+			utils.Log(log, moduleName, "Delivered all orders")
+			return
+		}
+	}
+}
+
+// runCalibration drives the elevator through the full shaft, and saves the building profile found at profilePath.
+func runCalibration(log *logrus.Logger, cfg config.Config, elevServerAddr string, profilePath string) {
+	utils.Log(log, moduleName, "Calibrating, driving the elevator through the full shaft")
+	drv := driver.NewTCP(elevServerAddr, cfg.NumFloors)
+	floorArrivals := make(chan int)
+	go drv.PollFloorSensor(floorArrivals)
+	profile, err := elev.Calibrate(drv, floorArrivals, cfg.NumFloors, cfg.Elevator.TravelTimeout, cfg.Elevator.DoorOpenTime)
+	if err != nil {
+		log.WithField("err", err).Fatalf(logString, moduleName, "Calibration failed")
+	}
+	err = travelmodel.SaveProfile(profilePath, profile)
+	utils.OkOrPanic(err)
+	log.WithFields(logrus.Fields{
+		"floors":   profile.NumFloors,
+		"segments": profile.Segments,
+		"dwell":    profile.DoorDwell,
+		"path":     profilePath,
+	}).Infof(logString, moduleName, "Saved building profile")
+}
+
+// reloadConfig re-reads the config file and applies the values that can be changed at runtime.
+// Changed values that need a restart are reported, but not applied. The number of floors is taken from
+// the building profile, if there is one.
+func reloadConfig(
+	log *logrus.Logger,
+	cfgStore *config.Store,
+	configPath string,
+	configFlags *config.Flags,
+	profile travelmodel.Profile,
+	hasProfile bool) {
+	newCfg, err := config.Load(configPath, configFlags)
+	if err != nil {
+		log.WithField("err", err).Warnf(logString, moduleName, "Could not reload config")
+		return
+	}
+	if hasProfile {
+		newCfg.NumFloors = profile.NumFloors
+	}
+	newCfg = newCfg.KeepNewerShared(cfgStore.Get())
+	needRestart, err := cfgStore.Update(newCfg)
+	if err != nil {
+		log.WithField("err", err).Warnf(logString, moduleName, "Could not reload config")
+		return
+	}
+	applyGlobalConfig(cfgStore.Get())
+	utils.Log(log, moduleName, "Reloaded config")
+	if len(needRestart) > 0 {
+		log.WithField("keys", needRestart).Warnf(logString, moduleName, "Changed values need a restart to apply")
+	}
+}
+
+// applyGlobalConfig applies the config values that are not owned by a single module.
+func applyGlobalConfig(cfg config.Config) {
+	err := utils.SetLogLevel(cfg.LogLevel)
+	utils.OkOrPanic(err)
+	pubsub.SetDiscoveryMode(cfg.Network.DiscoveryMode)
+}
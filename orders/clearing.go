@@ -0,0 +1,62 @@
+package orders
+
+import (
+	"github.com/sigtot/elevio"
+	"github.com/sigtot/sanntid/config"
+	"github.com/sigtot/sanntid/types"
+	"github.com/sigtot/sanntid/utils"
+)
+
+// clearOrders splits orders into the orders delivered when the elevator, moving in direction dir, stops for arrival,
+// and the orders remaining in the queue. The arrival is always delivered. With config.ClearAll, every order at the
+// floor is delivered. With config.ClearDirection, the cab orders and the hall orders in the direction the elevator
+// leaves in are delivered, and hall orders in the opposite direction only if there are no orders beyond the floor.
+func clearOrders(
+	orders []types.Order,
+	arrival types.Order,
+	dir elevio.MotorDirection,
+	policy string) (cleared []types.Order, remaining []types.Order, err error) {
+	leaveDir := dir
+	if arrival.Type == types.Hall {
+		leaveDir, err = utils.OrderDir2MDDir(arrival.Dir)
+		if err != nil {
+			return nil, orders, err
+		}
+	}
+	turning := leaveDir == elevio.MdStop || !ordersBeyond(orders, arrival.Floor, leaveDir)
+
+	cleared = append(cleared, arrival)
+	for _, order := range orders {
+		if utils.OrdersEqual(order, arrival) {
+			continue
+		}
+		if order.Floor != arrival.Floor {
+			remaining = append(remaining, order)
+			continue
+		}
+		deliver := policy == config.ClearAll || order.Type == types.Cab || turning
+		if !deliver {
+			orderDir, e := utils.OrderDir2MDDir(order.Dir)
+			if e != nil {
+				err = e
+			}
+			deliver = orderDir == leaveDir
+		}
+		if deliver {
+			cleared = append(cleared, order)
+		} else {
+			remaining = append(remaining, order)
+		}
+	}
+	return cleared, remaining, err
+}
+
+// ordersBeyond returns true if any of orders is beyond floor in direction dir.
+func ordersBeyond(orders []types.Order, floor int, dir elevio.MotorDirection) bool {
+	for _, order := range orders {
+		if (order.Floor-floor)*int(dir) > 0 {
+			return true
+		}
+	}
+	return false
+}
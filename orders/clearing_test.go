@@ -0,0 +1,84 @@
+package orders
+
+import (
+	"github.com/sigtot/elevio"
+	"github.com/sigtot/sanntid/config"
+	"github.com/sigtot/sanntid/types"
+	"reflect"
+	"testing"
+)
+
+func TestClearOrders(t *testing.T) {
+	cab1 := types.Order{Call: types.Call{Type: types.Cab, Floor: 1, Dir: types.InvalidDir}}
+	hallUp1 := types.Order{Call: types.Call{Type: types.Hall, Floor: 1, Dir: types.Up}}
+	hallDown1 := types.Order{Call: types.Call{Type: types.Hall, Floor: 1, Dir: types.Down}}
+	cab3 := types.Order{Call: types.Call{Type: types.Cab, Floor: 3, Dir: types.InvalidDir}}
+
+	cases := []struct {
+		name      string
+		orders    []types.Order
+		arrival   types.Order
+		dir       elevio.MotorDirection
+		policy    string
+		cleared   []types.Order
+		remaining []types.Order
+	}{
+		{
+			name:      "all clears every order at the floor",
+			orders:    []types.Order{cab1, hallUp1, hallDown1, cab3},
+			arrival:   cab1,
+			dir:       elevio.MdUp,
+			policy:    config.ClearAll,
+			cleared:   []types.Order{cab1, hallUp1, hallDown1},
+			remaining: []types.Order{cab3},
+		},
+		{
+			name:      "direction keeps hall order in the opposite direction",
+			orders:    []types.Order{cab1, hallUp1, hallDown1, cab3},
+			arrival:   cab1,
+			dir:       elevio.MdUp,
+			policy:    config.ClearDirection,
+			cleared:   []types.Order{cab1, hallUp1},
+			remaining: []types.Order{hallDown1, cab3},
+		},
+		{
+			name:      "direction follows the direction of a hall arrival",
+			orders:    []types.Order{cab1, hallUp1, hallDown1, cab3},
+			arrival:   hallUp1,
+			dir:       elevio.MdDown,
+			policy:    config.ClearDirection,
+			cleared:   []types.Order{hallUp1, cab1},
+			remaining: []types.Order{hallDown1, cab3},
+		},
+		{
+			name:      "direction clears both hall orders when turning",
+			orders:    []types.Order{hallUp1, hallDown1, cab1},
+			arrival:   cab1,
+			dir:       elevio.MdUp,
+			policy:    config.ClearDirection,
+			cleared:   []types.Order{cab1, hallUp1, hallDown1},
+			remaining: nil,
+		},
+		{
+			name:      "arrival not in queue is delivered",
+			orders:    []types.Order{cab3},
+			arrival:   hallUp1,
+			dir:       elevio.MdUp,
+			policy:    config.ClearDirection,
+			cleared:   []types.Order{hallUp1},
+			remaining: []types.Order{cab3},
+		},
+	}
+	for _, c := range cases {
+		cleared, remaining, err := clearOrders(c.orders, c.arrival, c.dir, c.policy)
+		if err != nil {
+			t.Fatalf("%s: %s\n", c.name, err)
+		}
+		if !reflect.DeepEqual(cleared, c.cleared) {
+			t.Errorf("%s: expected cleared %v but got %v\n", c.name, c.cleared, cleared)
+		}
+		if !reflect.DeepEqual(remaining, c.remaining) {
+			t.Errorf("%s: expected remaining %v but got %v\n", c.name, c.remaining, remaining)
+		}
+	}
+}
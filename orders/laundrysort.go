@@ -4,14 +4,30 @@ import (
 	"github.com/sigtot/elevio"
 	"github.com/sigtot/sanntid/types"
 	"github.com/sigtot/sanntid/utils"
+	"math"
 )
 
-func SortOrders(orders []types.Order, position float64, dir elevio.MotorDirection) (sorted []types.Order, err error) {
+// SortOrders sorts orders in the order they will be delivered by an elevator at position moving in direction dir,
+// in a building with numFloors floors. An idle elevator, with direction elevio.MdStop, starts in the direction
+// giving the shortest route.
+func SortOrders(
+	orders []types.Order,
+	position float64,
+	dir elevio.MotorDirection,
+	numFloors int) (sorted []types.Order, err error) {
 	// Choose a starting direction if elevator standing still
 	if dir == elevio.MdStop {
-		panic("SortOrders should never be called with a elevio.MdStop direction")
+		ordersCopy := make([]types.Order, len(orders))
+		copy(ordersCopy, orders)
+		sortedUp, errUp := SortOrders(ordersCopy, position, elevio.MdUp, numFloors)
+		sortedDown, errDown := SortOrders(orders, position, elevio.MdDown, numFloors)
+		if routeLength(sortedDown, position) < routeLength(sortedUp, position) {
+			return sortedDown, errDown
+		}
+		return sortedUp, errUp
 	}
 	// Iterate over one complete elevator cycle
+	topFloor := numFloors - 1
 	floor := roundPositionInDirection(position, dir)
 	startFloor := floor
 	startDir := dir
@@ -74,9 +90,19 @@ func SortOrders(orders []types.Order, position float64, dir elevio.MotorDirectio
 	return sorted, err
 }
 
+// routeLength returns the number of floors travelled when delivering sorted orders from position.
+func routeLength(sorted []types.Order, position float64) (length float64) {
+	for _, order := range sorted {
+		length += math.Abs(float64(order.Floor) - position)
+		position = float64(order.Floor)
+	}
+	return length
+}
+
+// roundPositionInDirection returns the next floor the elevator at position can stop at when moving in direction dir.
 func roundPositionInDirection(position float64, dir elevio.MotorDirection) (floor int) {
 	if dir == elevio.MdDown {
-		return int(position)
+		return int(math.Floor(position))
 	}
-	return int(position + 0.5)
+	return int(math.Ceil(position))
 }
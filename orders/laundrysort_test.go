@@ -4,12 +4,15 @@ import (
 	"fmt"
 	"github.com/sigtot/elevio"
 	"github.com/sigtot/sanntid/types"
+	"github.com/sigtot/sanntid/utils"
 	"log"
 	"math/rand"
 	"reflect"
 	"testing"
 )
 
+const testNumFloors = 4
+
 func TestSortOrders(t *testing.T) {
 	for k := 0; k < 100; k++ {
 		orders := []types.Order{
@@ -68,7 +71,7 @@ func TestSortOrders(t *testing.T) {
 
 		orders = scramble(orders)
 
-		sortedOrders, err := SortOrders(orders, 1, elevio.MdUp)
+		sortedOrders, err := SortOrders(orders, 1, elevio.MdUp, testNumFloors)
 		if err != nil {
 			t.Fatalf(err.Error())
 		}
@@ -102,7 +105,7 @@ func TestSortOrdersNice(t *testing.T) {
 
 		orders = scramble(orders)
 
-		sortedOrders, err := SortOrders(orders, 1.5, elevio.MdUp)
+		sortedOrders, err := SortOrders(orders, 1.5, elevio.MdUp, testNumFloors)
 		if err != nil {
 			t.Fatalf(err.Error())
 		}
@@ -136,7 +139,7 @@ func TestSortDownwards(t *testing.T) {
 
 		orders = scramble(orders)
 
-		sortedOrders, err := SortOrders(orders, 3, elevio.MdDown)
+		sortedOrders, err := SortOrders(orders, 3, elevio.MdDown, testNumFloors)
 		if err != nil {
 			t.Fatalf(err.Error())
 		}
@@ -152,12 +155,32 @@ func TestSortDownwards(t *testing.T) {
 
 func TestSortEmptyQueue(t *testing.T) {
 	var orders []types.Order
-	_, err := SortOrders(orders, 2.0, elevio.MdDown)
+	_, err := SortOrders(orders, 2.0, elevio.MdDown, testNumFloors)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 }
 
+func TestSortIdle(t *testing.T) {
+	orders := []types.Order{
+		{Call: types.Call{Type: types.Cab, Dir: types.InvalidDir, Floor: 0}},
+		{Call: types.Call{Type: types.Hall, Dir: types.Up, Floor: 3}},
+		{Call: types.Call{Type: types.Cab, Dir: types.InvalidDir, Floor: 1}},
+	}
+	expected := []types.Order{orders[2], orders[0], orders[1]}
+
+	// Going down first is the shortest route from floor 1
+	sorted, err := SortOrders(orders, 1.0, elevio.MdStop, testNumFloors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range expected {
+		if !utils.OrdersEqual(sorted[i], expected[i]) {
+			t.Fatalf("Expected %+v but got %+v\n", expected, sorted)
+		}
+	}
+}
+
 // SortOrders will sort orders that are closest to the current position first.
 func ExampleSortOrders() {
 	orders := []types.Order{
@@ -169,7 +192,7 @@ func ExampleSortOrders() {
 	dir := elevio.MdDown
 
 	fmt.Printf("Before: %+v\n", orders)
-	if sortedOrders, err := SortOrders(orders, pos, dir); err == nil {
+	if sortedOrders, err := SortOrders(orders, pos, dir, testNumFloors); err == nil {
 		fmt.Printf("After: %+v\n", sortedOrders)
 	}
 	// Output:
@@ -186,3 +209,24 @@ func scramble(orders []types.Order) []types.Order {
 	}
 	return orders
 }
+
+func TestRoundPositionInDirection(t *testing.T) {
+	cases := []struct {
+		position float64
+		dir      elevio.MotorDirection
+		expected int
+	}{
+		{1.0, elevio.MdUp, 1},
+		{1.1, elevio.MdUp, 2},
+		{1.9, elevio.MdUp, 2},
+		{2.0, elevio.MdDown, 2},
+		{1.9, elevio.MdDown, 1},
+		{1.1, elevio.MdDown, 1},
+	}
+	for _, c := range cases {
+		if floor := roundPositionInDirection(c.position, c.dir); floor != c.expected {
+			t.Errorf("Expected floor %d at position %.1f in direction %d but got %d\n",
+				c.expected, c.position, c.dir, floor)
+		}
+	}
+}
@@ -7,83 +7,140 @@ package orders
 import (
 	"encoding/json"
 	"github.com/sigtot/elevio"
+	"github.com/sigtot/sanntid/config"
 	"github.com/sigtot/sanntid/pubsub"
 	"github.com/sigtot/sanntid/types"
 	"github.com/sigtot/sanntid/utils"
-	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+	"sync"
 	"time"
 )
 
-const numFloors = 4
-const topFloor = numFloors - 1
 const bottomFloor = 0
 
-const counterDelay = 12000
-const tickInterval = 1000
-const deliveryDelayWeight = 1
-
 const moduleName = "ORDER HANDLER"
 
 // OrderHandler contains a unsorted list of all orders this elevator has to deliver. It also has a interface to the
 // elevator controller in order to receive the direction and position of the elevator. Finally it has a
 // delayed counter, which is added to the price calculation to penalize late deliveries. This makes the system
 // robust against motor failure and similar.
+// The queue is only changed by the order handler go-routine, and guarded by mu so that it can be read from others.
+// Every change to the queue is written to store before it takes effect.
 type OrderHandler struct {
+	cfg            *config.Store
+	mu             sync.Mutex
 	orders         []types.Order
+	currentGoal    types.Order
+	hasGoal        bool
+	store          *queueStore
 	delayedCounter utils.DelayedCounter
 	elev           ElevInterface
+	model          TravelEstimator
+	purchases      chan purchase
+	releases       chan chan []types.Order
+}
+
+// purchase is an order bought by the buyer. The stored channel is closed once the order is written to disk.
+type purchase struct {
+	order  types.Order
+	stored chan bool
 }
 
-// ElevInterface is used by the order handler to get the current position and direction of the elevator.
+// Snapshot is the state of the order queue at one point in time.
+type Snapshot struct {
+	Orders     []types.Order // In the order they were bought
+	NextGoal   types.Order   // The order the elevator is heading for, if HasGoal is set
+	HasGoal    bool
+	DelayCount int // Delivery delay ticks counted since the last delivery, zero when the queue is empty
+}
+
+// ElevInterface is used by the order handler to get the current position, direction and availability of the elevator.
 type ElevInterface interface {
 	GetDir() elevio.MotorDirection
 	GetPos() float64
+	Available() bool
 }
 
 // StartOrderHandler start a go-routine that sends the next goal floor on the currentGoals channel,
 // when new orders are received or the elevator arrives at the current goal floor.
+// On arrival, the orders delivered by the stop are chosen by the configured clearing policy,
+// and a delivered message is published for each of them.
+// Prices are based on the travel and door times estimated by model.
+// The queue is kept in db, and orders left in it by an earlier run are restored and served at once.
+// If db is nil, the queue is only kept in memory.
 func StartOrderHandler(
+	cfgStore *config.Store,
+	db *bolt.DB,
 	currentGoals chan types.Order,
 	arrivals chan types.Order,
-	elev ElevInterface) (*OrderHandler, chan types.Order) {
-	orderDeliveredPubChan := pubsub.StartPublisher(pubsub.OrderDeliveredDiscoveryPort)
-	newOrders := make(chan types.Order)
+	elev ElevInterface,
+	model TravelEstimator) *OrderHandler {
+	cfg := cfgStore.Get()
+	ports := pubsub.GetDiscoveryPorts(cfg.Network.DiscoveryBasePort)
+	orderDeliveredPubChan := pubsub.StartPublisher(ports.OrderDelivered)
 
-	oh := OrderHandler{elev: elev}
+	oh := OrderHandler{
+		cfg:       cfgStore,
+		elev:      elev,
+		model:     model,
+		purchases: make(chan purchase),
+		releases:  make(chan chan []types.Order),
+	}
+	if db != nil {
+		oh.store = &queueStore{db: db}
+	}
+	oh.delayedCounter.Start(cfg.Price.DeliveryDelay, cfg.Price.DeliveryDelayTick)
+
+	var log = utils.NewLogger()
 
-	var log = logrus.New()
+	restored, err := oh.store.load()
+	utils.OkOrPanic(err)
+	for _, order := range restored {
+		utils.LogOrder(log, moduleName, "Restored order", order)
+	}
+	oh.orders = restored
+	if len(oh.orders) > 0 {
+		oh.currentGoal, err = getNextGoal(oh.orders, oh.elev, cfg.NumFloors)
+		utils.OkOrPanic(err)
+		oh.hasGoal = true
+	}
 
 	go func() {
-		oh.delayedCounter.Start(counterDelay*time.Millisecond, tickInterval*time.Millisecond)
 		defer oh.delayedCounter.Stop()
 
+		if oh.hasGoal {
+			utils.LogOrder(log, moduleName, "Set next goal", oh.currentGoal)
+			currentGoals <- oh.currentGoal
+		}
+
 		for {
 			select {
-			case order := <-newOrders:
-				if len(oh.orders) == 0 {
-					oh.delayedCounter.Reset()
-				}
-				// Set next goal
-				oh.orders = append(oh.orders, order)
-				nextGoal, err := getNextGoal(oh.orders, oh.elev)
-				utils.OkOrPanic(err)
+			case p := <-oh.purchases:
+				nextGoal := oh.add(p.order)
+				close(p.stored)
 				utils.LogOrder(log, moduleName, "Set next goal", nextGoal)
 				currentGoals <- nextGoal
 			case arrival := <-arrivals:
-				// Delete corresponding order
-				for i, v := range oh.orders {
-					if utils.OrdersEqual(v, arrival) {
-						oh.orders = append(oh.orders[:i], oh.orders[i+1:]...)
-						utils.LogOrder(log, moduleName, "Deleted Order", arrival)
-						break
-					}
+				// Delete every order delivered by this stop
+				oh.mu.Lock()
+				cleared, remaining, err := clearOrders(
+					oh.orders, arrival, oh.elev.GetDir(), oh.cfg.Get().Elevator.ClearingPolicy)
+				utils.OkOrPanic(err)
+				utils.OkOrPanic(oh.store.remove(cleared))
+				oh.orders = remaining
+				for _, order := range cleared {
+					utils.LogOrder(log, moduleName, "Deleted Order", order)
 				}
 
 				oh.delayedCounter.Reset()
 
-				// Publish order delivered
-				arrivalJson, err := json.Marshal(arrival)
-				utils.OkOrPanic(err)
+				// Publish orders delivered
+				var clearedJson [][]byte
+				for _, order := range cleared {
+					orderJson, err := json.Marshal(order)
+					utils.OkOrPanic(err)
+					clearedJson = append(clearedJson, orderJson)
+				}
 				go func() {
 					timeout := time.After(1000 * time.Millisecond)
 					for {
@@ -91,41 +148,129 @@ func StartOrderHandler(
 						case <-timeout:
 							return
 						case <-time.After(100 * time.Millisecond):
-							orderDeliveredPubChan <- arrivalJson
+							for _, orderJson := range clearedJson {
+								orderDeliveredPubChan <- orderJson
+							}
 						}
 					}
 				}()
 
 				// Set next goal
-				if len(oh.orders) > 0 {
-					nextGoal, err := getNextGoal(oh.orders, oh.elev)
-					utils.LogOrder(log, moduleName, "Set next goal", nextGoal)
+				oh.hasGoal = len(oh.orders) > 0
+				if oh.hasGoal {
+					nextGoal, err := getNextGoal(oh.orders, oh.elev, cfg.NumFloors)
 					utils.OkOrPanic(err)
+					oh.currentGoal = nextGoal
+				}
+				nextGoal, hasGoal := oh.currentGoal, oh.hasGoal
+				oh.mu.Unlock()
+				if hasGoal {
+					utils.LogOrder(log, moduleName, "Set next goal", nextGoal)
 					currentGoals <- nextGoal
 				}
+			case reply := <-oh.releases:
+				// Keep the order the elevator is already heading for
+				var kept, released []types.Order
+				oh.mu.Lock()
+				for _, order := range oh.orders {
+					if order.Type == types.Hall && !(oh.hasGoal && utils.OrdersEqual(order, oh.currentGoal)) {
+						released = append(released, order)
+						utils.LogOrder(log, moduleName, "Released order", order)
+					} else {
+						kept = append(kept, order)
+					}
+				}
+				utils.OkOrPanic(oh.store.remove(released))
+				oh.orders = kept
+				oh.mu.Unlock()
+				reply <- released
 			}
 		}
 	}()
-	return &oh, newOrders
+	return &oh
+}
+
+// add writes order to the store and adds it to the queue, and returns the next goal.
+func (oh *OrderHandler) add(order types.Order) types.Order {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+	utils.OkOrPanic(oh.store.put(order))
+	if len(oh.orders) == 0 {
+		oh.delayedCounter.Reset()
+	}
+	oh.orders = append(oh.orders, order)
+	nextGoal, err := getNextGoal(oh.orders, oh.elev, oh.cfg.Get().NumFloors)
+	utils.OkOrPanic(err)
+	oh.currentGoal, oh.hasGoal = nextGoal, true
+	return nextGoal
+}
+
+// AddOrder adds a bought order to the queue, and returns once it is written to disk,
+// so that the purchase can safely be acknowledged.
+func (oh *OrderHandler) AddOrder(order types.Order) {
+	stored := make(chan bool)
+	oh.purchases <- purchase{order: order, stored: stored}
+	<-stored
 }
 
 // GetPrice calculates the price of the given call from the current elevator state, its queue and any accumulated delay
-// penalty based on the time elapsed since the last delivery
+// penalty based on the time elapsed since the last delivery. Hall calls are penalized while the elevator is unavailable.
 func (oh *OrderHandler) GetPrice(call types.Call) int {
-	price, err := calcPriceFromQueue(types.Order{Call: call}, oh.orders, oh.elev.GetPos(), oh.elev.GetDir())
+	cfg := oh.cfg.Get()
+	snapshot := oh.Snapshot()
+	price, err := calcPriceFromQueue(
+		types.Order{Call: call}, snapshot.Orders, oh.elev.GetPos(), oh.elev.GetDir(), cfg.NumFloors, cfg.Price, oh.model)
 	utils.OkOrPanic(err)
-	if len(oh.orders) > 0 {
-		count := <-oh.delayedCounter.Count
-		price += int(deliveryDelayWeight * count)
+	price += int(cfg.Price.DeliveryDelayWeight * float64(snapshot.DelayCount))
+	if call.Type == types.Hall && !oh.elev.Available() {
+		price += cfg.Price.UnavailablePenalty
 	}
 	return price
 }
 
+// ReleaseHallOrders removes the hall orders from the queue, except for the one the elevator is heading for,
+// and returns them so that they can be sold to another elevator.
+func (oh *OrderHandler) ReleaseHallOrders() []types.Order {
+	reply := make(chan []types.Order)
+	oh.releases <- reply
+	return <-reply
+}
+
+// Snapshot returns a copy of the order queue, the order the elevator is heading for and the delivery delay count.
+func (oh *OrderHandler) Snapshot() Snapshot {
+	oh.mu.Lock()
+	snapshot := Snapshot{
+		Orders:   make([]types.Order, len(oh.orders)),
+		NextGoal: oh.currentGoal,
+		HasGoal:  oh.hasGoal,
+	}
+	copy(snapshot.Orders, oh.orders)
+	oh.mu.Unlock()
+	if len(snapshot.Orders) > 0 {
+		snapshot.DelayCount = <-oh.delayedCounter.Count
+	}
+	return snapshot
+}
+
+// QueueLength returns the number of orders in the queue.
+func (oh *OrderHandler) QueueLength() int {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+	return len(oh.orders)
+}
+
+// NextGoal returns the order the elevator is currently heading for, and false if the queue is empty.
+func (oh *OrderHandler) NextGoal() (types.Order, bool) {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+	return oh.currentGoal, oh.hasGoal
+}
+
 // getNextGoal finds the next goal floor by sorting the order list and picking out the first element.
-func getNextGoal(orders []types.Order, elev ElevInterface) (types.Order, error) {
+func getNextGoal(orders []types.Order, elev ElevInterface, numFloors int) (types.Order, error) {
 	ordersCopy := make([]types.Order, len(orders))
 	copy(ordersCopy, orders)
-	sortedOrders, err := SortOrders(ordersCopy, elev.GetPos(), elev.GetDir())
+	sortedOrders, err := SortOrders(ordersCopy, elev.GetPos(), elev.GetDir(), numFloors)
 	if err != nil {
 		return types.Order{}, err
 	}
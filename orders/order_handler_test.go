@@ -3,17 +3,23 @@ package orders
 import (
 	"encoding/json"
 	"github.com/sigtot/elevio"
+	"github.com/sigtot/sanntid/config"
 	"github.com/sigtot/sanntid/pubsub"
 	"github.com/sigtot/sanntid/types"
 	"github.com/sigtot/sanntid/utils"
+	bolt "go.etcd.io/bbolt"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
 
 type MockElevatorController struct {
-	dir elevio.MotorDirection
-	pos float64
+	dir         elevio.MotorDirection
+	pos         float64
+	unavailable bool
 }
 
 func (mockElev MockElevatorController) GetDir() elevio.MotorDirection {
@@ -24,20 +30,49 @@ func (mockElev MockElevatorController) GetPos() float64 {
 	return mockElev.pos
 }
 
-func TestOrderHandler(t *testing.T) {
-	arrivals := make(chan types.Order)
-	currentGoals := make(chan types.Order)
+func (mockElev MockElevatorController) Available() bool {
+	return !mockElev.unavailable
+}
 
-	mockElev := MockElevatorController{dir: elevio.MdUp, pos: 2.0}
+// Every test order handler gets its own discovery ports, counted from testBasePort, so that the tests do not receive
+// each other's messages. No other package uses ports in this range.
+const testBasePort = 43000
 
-	orderDeliveredSubChan, _ := pubsub.StartSubscriber(pubsub.OrderDeliveredDiscoveryPort, "order del")
+var nextTestBasePort = testBasePort
 
-	_, newOrders := StartOrderHandler(currentGoals, arrivals, mockElev)
+// testOrderHandler is an order handler for an elevator at floor 0 going up, with the channels to the elevator.
+type testOrderHandler struct {
+	*OrderHandler
+	cfg          config.Config
+	currentGoals chan types.Order
+	arrivals     chan types.Order
+}
 
-	time.Sleep(500 * time.Millisecond)
+// startTestOrderHandler starts an order handler on its own discovery ports, keeping its queue in db if not nil.
+func startTestOrderHandler(t *testing.T, db *bolt.DB) testOrderHandler {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Network.DiscoveryBasePort = nextTestBasePort
+	nextTestBasePort += pubsub.NumTopics
+	th := testOrderHandler{
+		cfg:          cfg,
+		currentGoals: make(chan types.Order, 100),
+		arrivals:     make(chan types.Order),
+	}
+	mockElev := MockElevatorController{dir: elevio.MdUp, pos: 0.0}
+	th.OrderHandler = StartOrderHandler(config.NewStore(cfg), db, th.currentGoals, th.arrivals, mockElev, testModel)
+	return th
+}
+
+func TestOrderHandler(t *testing.T) {
+	oh := startTestOrderHandler(t, nil)
+	arrivals, currentGoals := oh.arrivals, oh.currentGoals
+
+	ports := pubsub.GetDiscoveryPorts(oh.cfg.Network.DiscoveryBasePort)
+	orderDeliveredSubChan, _ := pubsub.StartSubscriber(ports.OrderDelivered, pubsub.OrderDeliveredTopic)
 
 	newOrder := types.Order{Call: types.Call{Type: types.Hall, Floor: 2, Dir: types.Down}}
-	newOrders <- newOrder
+	oh.AddOrder(newOrder)
 
 	// Simulate elev receiving new goal
 	var currentGoal types.Order
@@ -48,7 +83,7 @@ func TestOrderHandler(t *testing.T) {
 	}
 
 	newerOrder := types.Order{Call: types.Call{Type: types.Hall, Floor: 3, Dir: types.Down}}
-	newOrders <- newerOrder
+	oh.AddOrder(newerOrder)
 	select {
 	case currentGoal = <-currentGoals:
 		if !utils.OrdersEqual(currentGoal, newerOrder) {
@@ -79,3 +114,146 @@ func TestOrderHandler(t *testing.T) {
 		t.Fatal("Timed out waiting for goal")
 	}
 }
+
+func TestGetPriceUnavailable(t *testing.T) {
+	cfg := config.Default()
+	oh := OrderHandler{
+		cfg:   config.NewStore(cfg),
+		elev:  MockElevatorController{dir: elevio.MdUp, pos: 1.0},
+		model: testModel,
+	}
+	hallCall := types.Call{Type: types.Hall, Floor: 3, Dir: types.Down}
+	cabCall := types.Call{Type: types.Cab, Floor: 3}
+	hallPrice := oh.GetPrice(hallCall)
+	cabPrice := oh.GetPrice(cabCall)
+
+	oh.elev = MockElevatorController{dir: elevio.MdUp, pos: 1.0, unavailable: true}
+	if price := oh.GetPrice(hallCall); price != hallPrice+cfg.Price.UnavailablePenalty {
+		t.Fatalf("Expected hall call price %d when unavailable but got %d\n",
+			hallPrice+cfg.Price.UnavailablePenalty, price)
+	}
+	if price := oh.GetPrice(cabCall); price != cabPrice {
+		t.Fatalf("Expected cab call price %d when unavailable but got %d\n", cabPrice, price)
+	}
+}
+
+func TestReleaseHallOrders(t *testing.T) {
+	oh := startTestOrderHandler(t, nil)
+
+	goal := types.Order{Call: types.Call{Type: types.Hall, Floor: 1, Dir: types.Up}}
+	hall := types.Order{Call: types.Call{Type: types.Hall, Floor: 3, Dir: types.Down}}
+	cab := types.Order{Call: types.Call{Type: types.Cab, Floor: 2, ElevatorID: "elev1"}}
+	oh.AddOrder(goal)
+	oh.AddOrder(hall)
+	oh.AddOrder(cab)
+
+	released := oh.ReleaseHallOrders()
+	if len(released) != 1 || !utils.OrdersEqual(released[0], hall) {
+		t.Fatalf("Expected only %+v to be released but got %+v\n", hall, released)
+	}
+	if oh.QueueLength() != 2 {
+		t.Fatalf("Expected goal and cab order to be kept but queue has %d orders\n", oh.QueueLength())
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	oh := startTestOrderHandler(t, nil)
+
+	if snapshot := oh.Snapshot(); len(snapshot.Orders) != 0 || snapshot.HasGoal {
+		t.Fatalf("Expected empty snapshot but got %+v\n", snapshot)
+	}
+
+	goal := types.Order{Call: types.Call{Type: types.Hall, Floor: 1, Dir: types.Up}}
+	hall := types.Order{Call: types.Call{Type: types.Hall, Floor: 3, Dir: types.Down}}
+	oh.AddOrder(hall)
+	oh.AddOrder(goal)
+	snapshot := oh.Snapshot()
+	if len(snapshot.Orders) != 2 || !utils.OrdersEqual(snapshot.Orders[0], hall) {
+		t.Fatalf("Expected both orders in the snapshot but got %+v\n", snapshot.Orders)
+	}
+	if !snapshot.HasGoal || !utils.OrdersEqual(snapshot.NextGoal, goal) {
+		t.Fatalf("Expected next goal %+v but got %+v\n", goal, snapshot.NextGoal)
+	}
+
+	// The snapshot is a copy
+	snapshot.Orders[0] = goal
+	if snapshot := oh.Snapshot(); !utils.OrdersEqual(snapshot.Orders[0], hall) {
+		t.Fatal("Expected snapshot to be unaffected by changes to an earlier snapshot")
+	}
+}
+
+func TestConcurrentQueueAccess(t *testing.T) {
+	oh := startTestOrderHandler(t, nil)
+
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 20; i++ {
+			order := types.Order{Call: types.Call{Type: types.Cab, Floor: i % oh.cfg.NumFloors, ElevatorID: "elev1"}}
+			oh.AddOrder(order)
+			oh.arrivals <- order
+		}
+		close(done)
+	}()
+
+	call := types.Call{Type: types.Hall, Floor: 2, Dir: types.Down}
+	for {
+		select {
+		case <-done:
+			for start := time.Now(); oh.QueueLength() != 0; time.Sleep(time.Millisecond) {
+				if time.Since(start) > 100*time.Millisecond {
+					t.Fatalf("Expected all orders delivered but queue has %d orders\n", oh.QueueLength())
+				}
+			}
+			return
+		default:
+			oh.GetPrice(call)
+			oh.Snapshot()
+			oh.QueueLength()
+			oh.NextGoal()
+		}
+	}
+}
+
+func TestRestoreQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := bolt.Open(filepath.Join(dir, "queue.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	oh := startTestOrderHandler(t, db)
+
+	delivered := types.Order{Call: types.Call{Type: types.Hall, Floor: 1, Dir: types.Up}}
+	hall := types.Order{Call: types.Call{Type: types.Hall, Floor: 3, Dir: types.Down}}
+	cab := types.Order{Call: types.Call{Type: types.Cab, Floor: 2, ElevatorID: "elev1"}}
+	oh.AddOrder(delivered)
+	oh.AddOrder(hall)
+	oh.AddOrder(cab)
+	oh.arrivals <- delivered
+	for start := time.Now(); oh.QueueLength() != 2; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 100*time.Millisecond {
+			t.Fatal("Timed out waiting for delivered order to be deleted")
+		}
+	}
+
+	// Start a new order handler on the same database, as after a crash
+	restored := startTestOrderHandler(t, db)
+	snapshot := restored.Snapshot()
+	if len(snapshot.Orders) != 2 || !utils.OrdersEqual(snapshot.Orders[0], hall) ||
+		!utils.OrdersEqual(snapshot.Orders[1], cab) {
+		t.Fatalf("Expected %+v and %+v to be restored but got %+v\n", hall, cab, snapshot.Orders)
+	}
+	select {
+	case goal := <-restored.currentGoals:
+		if !utils.OrdersEqual(goal, cab) {
+			t.Fatalf("Expected restored goal %+v but got %+v\n", cab, goal)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Timed out waiting for restored goal")
+	}
+}
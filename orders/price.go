@@ -2,24 +2,50 @@ package orders
 
 import (
 	"github.com/sigtot/elevio"
+	"github.com/sigtot/sanntid/config"
 	"github.com/sigtot/sanntid/types"
 	"github.com/sigtot/sanntid/utils"
-	"math"
+	"time"
 )
 
-const communityWeight = 2
-const individualWeight = 1
-const waitWeight = 3
-const travelWeight = 1
+// TravelEstimator estimates the time the elevator takes to travel between two positions, and to serve a stop.
+type TravelEstimator interface {
+	TravelTime(from float64, to float64) time.Duration
+	DoorDwell() time.Duration
+}
 
 // calcPriceFromQueue calculates the cost of newOrder, given the current queue of orders and elevator direction.
 // The trade-off between the cost of delaying the delivery of other orders and the delivery time of newOrder
-// can be tuned using the weights communityWeight and individualWeight.
-func calcPriceFromQueue(newOrder types.Order, orders []types.Order, position float64, dir elevio.MotorDirection) (int, error) {
+// can be tuned using the weights CommunityWeight and IndividualWeight.
+// Costs are in seconds, as estimated by model.
+// An idle elevator, with direction elevio.MdStop, is priced as if starting in the cheaper direction.
+func calcPriceFromQueue(
+	newOrder types.Order,
+	orders []types.Order,
+	position float64,
+	dir elevio.MotorDirection,
+	numFloors int,
+	weights config.PriceConfig,
+	model TravelEstimator) (int, error) {
+	if dir == elevio.MdStop {
+		priceUp, err := calcPriceFromQueue(newOrder, orders, position, elevio.MdUp, numFloors, weights, model)
+		if err != nil {
+			return -1, err
+		}
+		priceDown, err := calcPriceFromQueue(newOrder, orders, position, elevio.MdDown, numFloors, weights, model)
+		if err != nil {
+			return -1, err
+		}
+		if priceDown < priceUp {
+			return priceDown, nil
+		}
+		return priceUp, nil
+	}
+
 	// Create sorted, unique list of current orders
 	ordersCopy := make([]types.Order, len(orders))
 	copy(ordersCopy, orders)
-	sortedOrders, err := SortOrders(ordersCopy, position, dir)
+	sortedOrders, err := SortOrders(ordersCopy, position, dir, numFloors)
 	if err != nil {
 		return -1, err
 	}
@@ -28,7 +54,7 @@ func calcPriceFromQueue(newOrder types.Order, orders []types.Order, position flo
 	// Create sorted, unique list of orders with new order included
 	newSortedOrders := make([]types.Order, len(sortedOrders))
 	copy(newSortedOrders, sortedOrders)
-	newSortedOrders, err = SortOrders(append(newSortedOrders, newOrder), position, dir)
+	newSortedOrders, err = SortOrders(append(newSortedOrders, newOrder), position, dir, numFloors)
 	if err != nil {
 		return -1, err
 	}
@@ -37,21 +63,23 @@ func calcPriceFromQueue(newOrder types.Order, orders []types.Order, position flo
 	// Calculate price of adding new order to current order queue
 	newOrderIndex := findOrderIndex(newOrder, newSortedOrders)
 	numOrdersAfter := len(newSortedOrders) - (newOrderIndex + 1)
-	communityCost := (calcTotalQueueCost(newSortedOrders, position) - calcTotalQueueCost(sortedOrders, position)) * numOrdersAfter
-	individualCost := calcTotalQueueCost(newSortedOrders[:newOrderIndex+1], position)
-	return int(communityWeight*float64(communityCost) + individualWeight*float64(individualCost) + 0.5), nil
+	communityCost := (calcTotalQueueCost(newSortedOrders, position, weights, model) -
+		calcTotalQueueCost(sortedOrders, position, weights, model)) * numOrdersAfter
+	individualCost := calcTotalQueueCost(newSortedOrders[:newOrderIndex+1], position, weights, model)
+	return int(weights.CommunityWeight*float64(communityCost) + weights.IndividualWeight*float64(individualCost) + 0.5), nil
 }
 
 // calcTotalQueueCost iterates a sorted and unique order slice and calculates the total cost of the trajectory.
 // Should take in sorted and unique orders for correct behaviour.
-// The trade-off between cost of travel and waiting can be tuned using the weights travelWeight and waitWeight.
-func calcTotalQueueCost(orders []types.Order, position float64) int {
+// The cost is the estimated travel time weighted by TravelWeight, plus the estimated door dwell time
+// weighted by WaitWeight, in seconds.
+func calcTotalQueueCost(orders []types.Order, position float64, weights config.PriceConfig, model TravelEstimator) int {
 	cost := 0.0
 	for i := 0; i < len(orders); i++ {
-		cost += math.Abs(float64(orders[i].Floor)-position) * travelWeight
+		cost += model.TravelTime(position, float64(orders[i].Floor)).Seconds() * weights.TravelWeight
 		if (i == 0 || float64(orders[i].Floor) != position) && i < len(orders)-1 {
 			// Only add extra wait cost for different-floor, non-last orders
-			cost += waitWeight
+			cost += model.DoorDwell().Seconds() * weights.WaitWeight
 		}
 		position = float64(orders[i].Floor)
 	}
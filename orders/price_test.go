@@ -2,11 +2,19 @@ package orders
 
 import (
 	"github.com/sigtot/elevio"
+	"github.com/sigtot/sanntid/config"
+	"github.com/sigtot/sanntid/travelmodel"
 	"github.com/sigtot/sanntid/types"
 	"log"
 	"testing"
+	"time"
 )
 
+var testWeights = config.Default().Price
+
+// testModel makes every floor of travel and every stop cost one second
+var testModel = travelmodel.New(testNumFloors, time.Second, time.Second)
+
 func TestCalcPriceFromQueue(t *testing.T) {
 	orders := []types.Order{
 		{Call: types.Call{Type: types.Hall, Dir: types.Up, Floor: 2}},
@@ -17,7 +25,7 @@ func TestCalcPriceFromQueue(t *testing.T) {
 	}
 
 	newOrder := types.Order{Call: types.Call{Type: types.Hall, Dir: types.Down, Floor: 1}}
-	price, err := calcPriceFromQueue(newOrder, orders, 3.0, elevio.MdDown)
+	price, err := calcPriceFromQueue(newOrder, orders, 3.0, elevio.MdDown, testNumFloors, testWeights, testModel)
 	expectedCost := 20
 	if err != nil {
 		log.Fatal(err.Error())
@@ -28,7 +36,7 @@ func TestCalcPriceFromQueue(t *testing.T) {
 
 	// This order already exists, and so should only give individual price
 	oldOrder := types.Order{Call: types.Call{Type: types.Hall, Dir: types.Down, Floor: 2}}
-	price, err = calcPriceFromQueue(oldOrder, orders, 3.0, elevio.MdDown)
+	price, err = calcPriceFromQueue(oldOrder, orders, 3.0, elevio.MdDown, testNumFloors, testWeights, testModel)
 	expectedCost = 4 // (1 travel cost + 3 wait cost) * 1 individualWeight = 4
 	if err != nil {
 		log.Fatal(err.Error())
@@ -39,7 +47,7 @@ func TestCalcPriceFromQueue(t *testing.T) {
 
 	// Order where we are
 	sameFloorOrder := types.Order{Call: types.Call{Type: types.Hall, Dir: types.Down, Floor: 2}}
-	price, err = calcPriceFromQueue(sameFloorOrder, orders, 2.0, elevio.MdDown)
+	price, err = calcPriceFromQueue(sameFloorOrder, orders, 2.0, elevio.MdDown, testNumFloors, testWeights, testModel)
 	expectedCost = 0
 	if err != nil {
 		log.Fatal(err.Error())
@@ -60,12 +68,12 @@ func TestCalcTotalQueueCost(t *testing.T) {
 
 	dir := elevio.MdUp
 	pos := 3.0
-	orders, err := SortOrders(orders, pos, dir)
+	orders, err := SortOrders(orders, pos, dir, testNumFloors)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 	orders = removeDupesSorted(orders)
-	cost := calcTotalQueueCost(orders, pos)
+	cost := calcTotalQueueCost(orders, pos, testWeights, testModel)
 	expectedCost := 14
 	if cost != expectedCost {
 		log.Fatalf("Got cost %d but expected %d\n", cost, expectedCost)
@@ -76,7 +84,7 @@ func TestCalcPriceFromEmptyQueue(t *testing.T) {
 	var orders []types.Order
 
 	newOrder := types.Order{Call: types.Call{Type: types.Hall, Dir: types.Down, Floor: 1}}
-	price, err := calcPriceFromQueue(newOrder, orders, 3.0, elevio.MdDown)
+	price, err := calcPriceFromQueue(newOrder, orders, 3.0, elevio.MdDown, testNumFloors, testWeights, testModel)
 	expectedCost := 2
 	if err != nil {
 		log.Fatal(err.Error())
@@ -90,3 +98,44 @@ func TestRemoveDupesFromEmptyQueue(t *testing.T) {
 	var orders []types.Order
 	_ = removeDupesSorted(orders)
 }
+
+func TestCalcPriceIdle(t *testing.T) {
+	orders := []types.Order{
+		{Call: types.Call{Type: types.Cab, Dir: types.InvalidDir, Floor: 0}},
+	}
+	newOrder := types.Order{Call: types.Call{Type: types.Hall, Dir: types.Down, Floor: 2}}
+	priceUp, err := calcPriceFromQueue(newOrder, orders, 1.0, elevio.MdUp, testNumFloors, testWeights, testModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priceDown, err := calcPriceFromQueue(newOrder, orders, 1.0, elevio.MdDown, testNumFloors, testWeights, testModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	price, err := calcPriceFromQueue(newOrder, orders, 1.0, elevio.MdStop, testNumFloors, testWeights, testModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if priceUp == priceDown || (price != priceUp && price != priceDown) || price > priceUp || price > priceDown {
+		t.Fatalf("Expected the cheaper of %d and %d for an idle elevator but got %d\n", priceUp, priceDown, price)
+	}
+}
+
+func TestCalcPriceSlowElevator(t *testing.T) {
+	orders := []types.Order{
+		{Call: types.Call{Type: types.Cab, Dir: types.InvalidDir, Floor: 3}},
+	}
+	newOrder := types.Order{Call: types.Call{Type: types.Hall, Dir: types.Down, Floor: 2}}
+	slowModel := travelmodel.New(testNumFloors, 3*time.Second, time.Second)
+	price, err := calcPriceFromQueue(newOrder, orders, 0.0, elevio.MdUp, testNumFloors, testWeights, testModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slowPrice, err := calcPriceFromQueue(newOrder, orders, 0.0, elevio.MdUp, testNumFloors, testWeights, slowModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slowPrice <= price {
+		t.Fatalf("Expected slow elevator to bid higher than %d but got %d\n", price, slowPrice)
+	}
+}
@@ -0,0 +1,96 @@
+package orders
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"github.com/sigtot/sanntid/types"
+	bolt "go.etcd.io/bbolt"
+)
+
+const queueBucketName = "queue"
+
+// queueStore keeps a copy of the order queue in a bolt database, so that it survives crashes and power loss.
+// Orders are keyed by a sequence number, so that they are loaded in the order they were bought.
+// A nil queueStore keeps nothing.
+type queueStore struct {
+	db *bolt.DB
+}
+
+// put writes order to the database. The write is synced to disk before put returns.
+func (s *queueStore) put(order types.Order) error {
+	if s == nil {
+		return nil
+	}
+	js, err := json.Marshal(order)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(queueBucketName))
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return b.Put(key, js)
+	})
+}
+
+// remove deletes every copy of the given orders from the database.
+func (s *queueStore) remove(orders []types.Order) error {
+	if s == nil || len(orders) == 0 {
+		return nil
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(queueBucketName))
+		if b == nil {
+			return nil
+		}
+		var keys [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			stored := types.Order{}
+			if err := json.Unmarshal(v, &stored); err != nil {
+				return err
+			}
+			if findOrderIndex(stored, orders) >= 0 {
+				keys = append(keys, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// load returns the orders in the database.
+func (s *queueStore) load() (orders []types.Order, err error) {
+	if s == nil {
+		return nil, nil
+	}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(queueBucketName))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			order := types.Order{}
+			if err := json.Unmarshal(v, &order); err != nil {
+				return err
+			}
+			orders = append(orders, order)
+			return nil
+		})
+	})
+	return orders, err
+}
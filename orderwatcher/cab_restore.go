@@ -0,0 +1,120 @@
+package orderwatcher
+
+import (
+	"encoding/json"
+	"github.com/sigtot/sanntid/config"
+	"github.com/sigtot/sanntid/types"
+	"github.com/sigtot/sanntid/utils"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+const cabRequestInterval = 100 * time.Millisecond
+
+// Cab replies received while no restore is running are dropped when this many are waiting
+const cabReplyBufSize = 16
+
+// cabRequest asks the order watchers on the network for the cab orders of ElevatorID.
+type cabRequest struct {
+	ElevatorID string
+}
+
+// cabReply holds the cab calls of ElevatorID found in the database of SenderID.
+type cabReply struct {
+	ElevatorID string
+	SenderID   string
+	Calls      []types.Call
+}
+
+// OrderWatcher is the handle of a running order watcher, used to restore the cab orders of this elevator.
+type OrderWatcher struct {
+	cfgStore          *config.Store
+	elevatorID        string
+	cabRequestPubChan chan []byte
+	ackPubChan        chan []byte
+	cabReplies        chan cabReply // Replies from peers to the cab requests of this elevator
+}
+
+// RestoreCabOrders asks the order watchers of the other elevators for the cab orders of this elevator, and returns
+// the union of their answers. Requests are repeated until the cab restore timeout has passed, so that peers
+// that are discovered late are heard too. It is meant to be called at startup, before any cab calls are bought.
+func (ow *OrderWatcher) RestoreCabOrders() []types.Order {
+	requestJson, err := json.Marshal(cabRequest{ElevatorID: ow.elevatorID})
+	utils.OkOrPanic(err)
+
+	log := utils.NewLogger()
+	requestTicker := time.NewTicker(cabRequestInterval)
+	defer requestTicker.Stop()
+	timeout := time.After(ow.cfgStore.Get().OrderWatcher.CabRestoreTimeout)
+	var restored []types.Order
+	for {
+		select {
+		case <-requestTicker.C:
+			ow.cabRequestPubChan <- requestJson
+		case reply := <-ow.cabReplies:
+			for _, call := range reply.Calls {
+				restored = mergeOrder(restored, types.Order{Call: call})
+			}
+		case <-timeout:
+			log.WithField("orders", len(restored)).Infof(logString, moduleName, "Restored cab orders from peers")
+			return restored
+		}
+	}
+}
+
+// AnnounceOrders acknowledges orders on the network on behalf of this elevator, as if they were just bought.
+// The order watchers then restart their time to delivery, so that restored orders are not resold right away.
+func (ow *OrderWatcher) AnnounceOrders(orders []types.Order) {
+	for _, order := range orders {
+		ack := types.Ack{Bid: types.Bid{Call: order.Call, ElevatorID: ow.elevatorID}}
+		ackJson, err := json.Marshal(ack)
+		utils.OkOrPanic(err)
+		ow.ackPubChan <- ackJson
+	}
+}
+
+// forwardCabReply passes a reply to a running restore of this elevator's cab orders. Replies for other elevators,
+// and replies arriving when the buffer is full, are dropped.
+func (ow *OrderWatcher) forwardCabReply(reply cabReply) {
+	if reply.ElevatorID != ow.elevatorID || reply.SenderID == ow.elevatorID {
+		return
+	}
+	select {
+	case ow.cabReplies <- reply:
+	default:
+	}
+}
+
+// mergeOrder appends order to orders, unless an equal order is already there.
+func mergeOrder(orders []types.Order, order types.Order) []types.Order {
+	for _, o := range orders {
+		if utils.OrdersEqual(o, order) {
+			return orders
+		}
+	}
+	return append(orders, order)
+}
+
+// cabCalls returns the undelivered cab calls of ownerID in db.
+func cabCalls(db *bolt.DB, ownerID string) (calls []types.Call, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(ownerID))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k []byte, v []byte) error {
+			if string(v) == "" {
+				return nil
+			}
+			ao, err := unmarshalAssignedOrder(v)
+			if err != nil {
+				return err
+			}
+			if ao.Call.Type == types.Cab {
+				calls = append(calls, ao.Call)
+			}
+			return nil
+		})
+	})
+	return calls, err
+}
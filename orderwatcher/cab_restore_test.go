@@ -0,0 +1,85 @@
+package orderwatcher
+
+import (
+	"encoding/json"
+	"github.com/sigtot/sanntid/config"
+	"github.com/sigtot/sanntid/types"
+	"github.com/sigtot/sanntid/utils"
+	bolt "go.etcd.io/bbolt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTestDb(t *testing.T, dir string, name string) *bolt.DB {
+	db, err := bolt.Open(filepath.Join(dir, name), testDbPerms, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestRestoreCabOrders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cabrestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	peerDb := openTestDb(t, dir, "peer.db")
+	defer peerDb.Close()
+	localDb := openTestDb(t, dir, "local.db")
+	defer localDb.Close()
+
+	// The peer has held the orders for a while
+	assignTime := time.Now().Add(-10 * time.Minute)
+	cab := types.Call{Type: types.Cab, Dir: types.InvalidDir, Floor: 2, ElevatorID: testElevID}
+	otherCab := types.Call{Type: types.Cab, Dir: types.InvalidDir, Floor: 1, ElevatorID: "other"}
+	for _, call := range []types.Call{cab, otherCab} {
+		aoJson, err := json.Marshal(assignedOrder{OwnerID: call.ElevatorID, AssignTime: assignTime, Call: call})
+		utils.OkOrPanic(err)
+		utils.OkOrPanic(writeToDb(peerDb, call.ElevatorID, strconv.Itoa(call.Floor), aoJson))
+	}
+
+	cfg := config.Default()
+	cfg.Network.DiscoveryBasePort = 42100
+	cfg.OrderWatcher.BaseTTD = time.Hour
+	cfg.OrderWatcher.CabRestoreTimeout = 1500 * time.Millisecond
+	quit := make(chan int)
+	var wg sync.WaitGroup
+	StartOrderWatcher(config.NewStore(cfg), make(chan types.Call), peerDb, dir, testWatcherID, quit, &wg)
+	local := StartOrderWatcher(config.NewStore(cfg), make(chan types.Call), localDb, dir, testElevID, quit, &wg)
+	defer func() {
+		quit <- 0
+		quit <- 0
+		wg.Wait()
+	}()
+
+	restored := local.RestoreCabOrders()
+	if len(restored) != 1 || restored[0].Call != cab {
+		t.Fatalf("Expected only %+v to be restored but got %+v\n", cab, restored)
+	}
+
+	local.AnnounceOrders(restored)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var ao *assignedOrder
+		err := peerDb.View(func(tx *bolt.Tx) error {
+			ao, err = unmarshalAssignedOrder(tx.Bucket([]byte(testElevID)).Get([]byte(strconv.Itoa(cab.Floor))))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ao.AssignTime.After(assignTime) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Peer did not restart the time to delivery of the restored order")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"bytes"
 	"compress/gzip"
 	"encoding/json"
-	"github.com/sigtot/sanntid/mac"
+	"github.com/sigtot/sanntid/config"
 	"github.com/sigtot/sanntid/pubsub"
 	"github.com/sigtot/sanntid/utils"
 	bolt "go.etcd.io/bbolt"
@@ -13,23 +13,19 @@ import (
 	"time"
 )
 
-const dbDistributeInterval = 10000
-
 type dbMsg struct {
 	Buf      []byte
 	SenderID string
 }
 
 // StartDbDistributor starts distributing the database of orders.
-// It compresses the file and publishes it as a DbMsg on the network.
-func StartDbDistributor(db *bolt.DB, dbName string, quit <-chan int) chan int {
-	dbPubChan := pubsub.StartPublisher(pubsub.DbDiscoveryPort)
-	elevatorID, err := mac.GetMacAddr()
-	utils.OkOrPanic(err)
+// It compresses the file and publishes it as a DbMsg on the network, tagged with the elevatorID of the sender.
+func StartDbDistributor(cfg config.Config, db *bolt.DB, dbName string, elevatorID string, quit <-chan int) chan int {
+	dbPubChan := pubsub.StartPublisher(pubsub.GetDiscoveryPorts(cfg.Network.DiscoveryBasePort).Db)
 	quitAck := make(chan int)
 
 	go func() {
-		dbDistributeTicker := time.NewTicker(dbDistributeInterval * time.Millisecond)
+		dbDistributeTicker := time.NewTicker(cfg.OrderWatcher.DbDistributeInterval)
 		for {
 			select {
 			case <-dbDistributeTicker.C:
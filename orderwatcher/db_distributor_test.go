@@ -5,6 +5,7 @@ import (
 	"compress/gzip"
 	"encoding/json"
 	"fmt"
+	"github.com/sigtot/sanntid/config"
 	"github.com/sigtot/sanntid/pubsub"
 	bolt "go.etcd.io/bbolt"
 	"io"
@@ -31,7 +32,7 @@ func TestStartDbDistributor(t *testing.T) {
 	dbSubChan, _ := pubsub.StartSubscriber(pubsub.DbDiscoveryPort, pubsub.DbDiscoveryTopic)
 
 	quit := make(chan int)
-	StartDbDistributor(db, testDbName, quit)
+	StartDbDistributor(config.Default(), db, testDbName, testWatcherID, quit)
 
 	dbMsgJson := <-dbSubChan
 	dbMsg := dbMsg{}
@@ -11,7 +11,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/sigtot/sanntid/mac"
+	"github.com/sigtot/sanntid/config"
 	"github.com/sigtot/sanntid/pubsub"
 	"github.com/sigtot/sanntid/types"
 	"github.com/sigtot/sanntid/utils"
@@ -20,6 +20,7 @@ import (
 	"io"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"strconv"
 	"sync"
 	"time"
@@ -28,15 +29,9 @@ import (
 const hallUpBucketName = "hall_up"
 const hallDownBucketName = "hall_down"
 
-const dbTraversalInterval = 500
-
-const baseTTD = 10000
-const randTTDOffset = 2000
-
 const moduleName = "ORDER WATCHER"
 const logString = "%-15s%s"
 
-const dbCopyDir = "/tmp"
 const dbCopyName = "orderwatcher_copy.db"
 const dbCopyPerms = 0600
 const dbCopyTimeout = 500
@@ -51,20 +46,43 @@ type assignedOrder struct {
 // and updates a local database that stores all orders.
 // It traverses the database at regular intervals and sends orders that take too long to deliver to the seller.
 // The order watcher also listens for database files sent by the other db distributors
-// and synchronizes them with the local database.
-// An order watcher subscribes to sale acknowledgements, order deliveries and db distribution messages.
-func StartOrderWatcher(callsForSale chan types.Call, db *bolt.DB, quit <-chan int, wg *sync.WaitGroup) {
-	ackSubChan, _ := pubsub.StartSubscriber(pubsub.AckDiscoveryPort, pubsub.AckTopic)
-	orderDeliveredSubChan, _ := pubsub.StartSubscriber(pubsub.OrderDeliveredDiscoveryPort, pubsub.OrderDeliveredTopic)
-	dbSubChan, _ := pubsub.StartSubscriber(pubsub.DbDiscoveryPort, pubsub.DbDiscoveryTopic)
-
-	elevatorID, _ := mac.GetMacAddr()
-	log := logrus.New()
+// and synchronizes them with the local database. Received databases are temporarily copied to dataDir.
+// When a restarted elevator asks for its cab orders, the ones in the local database are sent back to it.
+// The returned OrderWatcher restores the cab orders of elevatorID in the same way.
+// An order watcher subscribes to sale acknowledgements, order deliveries, db distribution messages, cab order
+// requests and replies, and publishes cab order requests, replies and acknowledgements of restored orders.
+// Databases sent by elevatorID itself are not synced.
+func StartOrderWatcher(
+	cfgStore *config.Store,
+	callsForSale chan types.Call,
+	db *bolt.DB,
+	dataDir string,
+	elevatorID string,
+	quit <-chan int,
+	wg *sync.WaitGroup) *OrderWatcher {
+	cfg := cfgStore.Get()
+	ports := pubsub.GetDiscoveryPorts(cfg.Network.DiscoveryBasePort)
+	ackSubChan, _ := pubsub.StartSubscriber(ports.Ack, pubsub.AckTopic)
+	orderDeliveredSubChan, _ := pubsub.StartSubscriber(ports.OrderDelivered, pubsub.OrderDeliveredTopic)
+	dbSubChan, _ := pubsub.StartSubscriber(ports.Db, pubsub.DbDiscoveryTopic)
+	cabRequestSubChan, _ := pubsub.StartSubscriber(ports.CabRequest, pubsub.CabRequestTopic)
+	cabReplySubChan, _ := pubsub.StartSubscriber(ports.CabReply, pubsub.CabReplyTopic)
+	cabReplyPubChan := pubsub.StartPublisher(ports.CabReply)
+	ow := OrderWatcher{
+		cfgStore:          cfgStore,
+		elevatorID:        elevatorID,
+		cabRequestPubChan: pubsub.StartPublisher(ports.CabRequest),
+		ackPubChan:        pubsub.StartPublisher(ports.Ack),
+		cabReplies:        make(chan cabReply, cabReplyBufSize),
+	}
+
+	dbCopyPath := filepath.Join(dataDir, dbCopyName)
+	log := utils.NewLogger()
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
-		dbTraversalTicker := time.NewTicker(dbTraversalInterval * time.Millisecond)
+		dbTraversalTicker := time.NewTicker(cfg.OrderWatcher.DbTraversalInterval)
 		defer dbTraversalTicker.Stop()
 		for {
 			select {
@@ -97,6 +115,7 @@ func StartOrderWatcher(callsForSale chan types.Call, db *bolt.DB, quit <-chan in
 				utils.OkOrPanic(err)
 			case <-dbTraversalTicker.C:
 				// Traverse database and identify orders not delivered in time
+				ttdCfg := cfgStore.Get().OrderWatcher
 				err := db.Update(func(tx *bolt.Tx) error {
 					return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
 						err := b.ForEach(func(k []byte, v []byte) error {
@@ -104,7 +123,7 @@ func StartOrderWatcher(callsForSale chan types.Call, db *bolt.DB, quit <-chan in
 								return nil
 							}
 							if ao, err := unmarshalAssignedOrder(v); err == nil {
-								if time.Now().After(ao.AssignTime.Add(getTTD())) {
+								if time.Now().After(ao.AssignTime.Add(getTTD(ttdCfg))) {
 									// Resell order
 									callsForSale <- ao.Call
 									logAssignedOrder(log, moduleName, "Sent order to seller for resale", *ao)
@@ -126,6 +145,27 @@ func StartOrderWatcher(callsForSale chan types.Call, db *bolt.DB, quit <-chan in
 					})
 				})
 				utils.OkOrPanic(err)
+			case requestJson := <-cabRequestSubChan:
+				request := cabRequest{}
+				err := json.Unmarshal(requestJson, &request)
+				utils.OkOrPanic(err)
+				if request.ElevatorID == elevatorID {
+					break // The local db is lost or up to date
+				}
+				calls, err := cabCalls(db, request.ElevatorID)
+				utils.OkOrPanic(err)
+				if len(calls) == 0 {
+					break
+				}
+				reply := cabReply{ElevatorID: request.ElevatorID, SenderID: elevatorID, Calls: calls}
+				replyJson, err := json.Marshal(reply)
+				utils.OkOrPanic(err)
+				cabReplyPubChan <- replyJson
+			case replyJson := <-cabReplySubChan:
+				reply := cabReply{}
+				err := json.Unmarshal(replyJson, &reply)
+				utils.OkOrPanic(err)
+				ow.forwardCabReply(reply)
 			case dbMsgJson := <-dbSubChan:
 				// Unmarshal db message
 				dbMsg := dbMsg{}
@@ -142,7 +182,7 @@ func StartOrderWatcher(callsForSale chan types.Call, db *bolt.DB, quit <-chan in
 				utils.OkOrPanic(err)
 
 				// Copy received db file
-				f, err := os.Create(fmt.Sprintf("%s/%s", dbCopyDir, dbCopyName))
+				f, err := os.Create(dbCopyPath)
 				utils.OkOrPanic(err)
 				if _, err = io.Copy(f, zr); err != nil {
 					panic(err)
@@ -153,7 +193,7 @@ func StartOrderWatcher(callsForSale chan types.Call, db *bolt.DB, quit <-chan in
 				utils.OkOrPanic(err)
 
 				// Open db from copied db file
-				dbCopy, err := bolt.Open(fmt.Sprintf("%s/%s", dbCopyDir, dbCopyName), dbCopyPerms, &bolt.Options{Timeout: dbCopyTimeout * time.Millisecond})
+				dbCopy, err := bolt.Open(dbCopyPath, dbCopyPerms, &bolt.Options{Timeout: dbCopyTimeout * time.Millisecond})
 				utils.OkOrPanic(err)
 
 				// Do union of received db and local db to sync state
@@ -189,6 +229,7 @@ func StartOrderWatcher(callsForSale chan types.Call, db *bolt.DB, quit <-chan in
 			}
 		}
 	}()
+	return &ow
 }
 
 func writeToDb(db *bolt.DB, bName string, key string, value []byte) error {
@@ -227,8 +268,8 @@ func unmarshalAssignedOrder(aoJson []byte) (*assignedOrder, error) {
 }
 
 // Returns time to delivery for order, randomly distributed around its base time
-func getTTD() time.Duration {
-	return time.Duration(baseTTD+(rand.Intn(randTTDOffset)-randTTDOffset/2)) * time.Millisecond
+func getTTD(cfg config.OrderWatcherConfig) time.Duration {
+	return cfg.BaseTTD + time.Duration(rand.Int63n(int64(cfg.RandTTDOffset))) - cfg.RandTTDOffset/2
 }
 
 func logAssignedOrder(log *logrus.Logger, moduleName string, info string, ao assignedOrder) {
@@ -2,9 +2,12 @@ package orderwatcher
 
 import (
 	"encoding/json"
+	"github.com/sigtot/sanntid/config"
 	"github.com/sigtot/sanntid/pubsub"
 	"github.com/sigtot/sanntid/types"
 	bolt "go.etcd.io/bbolt"
+	"io/ioutil"
+	"os"
 	"strconv"
 	"sync"
 	"testing"
@@ -13,6 +16,7 @@ import (
 
 const testDbName = "test.db"
 const testElevID = "cb:32:f6:7e:2d:cc"
+const testWatcherID = "order-watcher-test"
 const testDbPerms = 0600
 const testDbTimeout = 1000
 
@@ -35,11 +39,17 @@ func TestStartOrderWatcher(t *testing.T) {
 	ackPubChan := pubsub.StartPublisher(pubsub.AckDiscoveryPort)
 	orderDelPubChan := pubsub.StartPublisher(pubsub.OrderDeliveredDiscoveryPort)
 
+	dataDir, err := ioutil.TempDir("", "orderwatcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
 	callsForSale := make(chan types.Call)
 	quit := make(chan int)
 	var wg sync.WaitGroup
-	StartOrderWatcher(callsForSale, db, quit, &wg)
-	StartDbDistributor(db, testDbName, quit)
+	StartOrderWatcher(config.NewStore(config.Default()), callsForSale, db, dataDir, testWatcherID, quit, &wg)
+	StartDbDistributor(config.Default(), db, testDbName, testWatcherID, quit)
 
 	orders := []types.Order{
 		{Call: types.Call{Type: types.Hall, Dir: types.Up, Floor: 1}},
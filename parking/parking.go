@@ -0,0 +1,141 @@
+/*
+Package parking sends idle elevators to home floors, so that they are spread across the building while waiting
+for calls. The idle elevators are found from the telemetry published by every node, and each node assigns home
+floors to all of them in the same way, so that no two elevators park at the same floor.
+*/
+package parking
+
+import (
+	"encoding/json"
+	"github.com/sigtot/sanntid/config"
+	"github.com/sigtot/sanntid/pubsub"
+	"github.com/sigtot/sanntid/types"
+	"github.com/sigtot/sanntid/utils"
+	"math"
+	"sort"
+	"time"
+)
+
+const pollInterval = 100 * time.Millisecond
+
+// Telemetry older than this many telemetry intervals is considered stale
+const staleIntervals = 3
+
+const moduleName = "PARKING"
+const logString = "%-15s%s"
+
+// Elevator is the interface to the elevator controller, used to find out whether the elevator is idle.
+type Elevator interface {
+	Idle() bool
+	GetPos() float64
+	Status() types.ElevatorStatus
+}
+
+// Queue is the interface to the order handler, used to find out whether the elevator has any orders.
+type Queue interface {
+	QueueLength() int
+}
+
+type peer struct {
+	state    types.ElevatorState
+	received time.Time
+}
+
+// StartParking starts sending elevatorID to a home floor on parkingGoals when it has been idle for the parking
+// timeout.
+func StartParking(cfgStore *config.Store, elevatorID string, elev Elevator, queue Queue, parkingGoals chan<- int) {
+	cfg := cfgStore.Get()
+	ports := pubsub.GetDiscoveryPorts(cfg.Network.DiscoveryBasePort)
+	telemetrySubChan, _ := pubsub.StartSubscriber(ports.Telemetry, pubsub.TelemetryTopic)
+
+	log := utils.NewLogger()
+
+	go func() {
+		pollTicker := time.NewTicker(pollInterval)
+		defer pollTicker.Stop()
+		peers := make(map[string]peer)
+		var idleSince time.Time
+		parked := false
+		for {
+			select {
+			case js := <-telemetrySubChan:
+				state := types.ElevatorState{}
+				err := json.Unmarshal(js, &state)
+				utils.OkOrPanic(err)
+				if state.ElevatorID != elevatorID {
+					peers[state.ElevatorID] = peer{state: state, received: time.Now()}
+				}
+			case <-pollTicker.C:
+				cfg := cfgStore.Get()
+				if !idle(elev, queue) {
+					idleSince = time.Time{}
+					parked = false
+					continue
+				}
+				if idleSince.IsZero() {
+					idleSince = time.Now()
+				}
+				if parked || time.Since(idleSince) < cfg.Parking.Timeout {
+					continue
+				}
+
+				positions := map[string]float64{elevatorID: elev.GetPos()}
+				for id, p := range peers {
+					if time.Since(p.received) > staleIntervals*cfg.Cluster.TelemetryInterval {
+						delete(peers, id)
+					} else if p.state.QueueLength == 0 && p.state.Status.Available() {
+						positions[id] = p.state.Position
+					}
+				}
+				parked = true
+				floor, ok := assignHomeFloors(positions, cfg.Parking.HomeFloors)[elevatorID]
+				if !ok || float64(floor) == elev.GetPos() {
+					continue
+				}
+				log.WithField("floor", floor).Infof(logString, moduleName, "Sending idle elevator to home floor")
+				parkingGoals <- floor
+			}
+		}
+	}()
+}
+
+// idle returns true if the elevator has no orders and nothing else keeping it from parking.
+func idle(elev Elevator, queue Queue) bool {
+	return elev.Idle() && queue.QueueLength() == 0 && elev.Status().Available()
+}
+
+// assignHomeFloors assigns home floors to the idle elevators at positions. The elevator and home floor closest to each
+// other are paired first, with ties broken by elevator id and floor, until all elevators or all home floors are taken.
+func assignHomeFloors(positions map[string]float64, homeFloors []int) map[string]int {
+	type pair struct {
+		id    string
+		floor int
+		dist  float64
+	}
+	var pairs []pair
+	for id, pos := range positions {
+		for _, floor := range homeFloors {
+			pairs = append(pairs, pair{id: id, floor: floor, dist: math.Abs(float64(floor) - pos)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].dist != pairs[j].dist {
+			return pairs[i].dist < pairs[j].dist
+		}
+		if pairs[i].id != pairs[j].id {
+			return pairs[i].id < pairs[j].id
+		}
+		return pairs[i].floor < pairs[j].floor
+	})
+
+	taken := make(map[int]bool)
+	assigned := make(map[string]int)
+	for _, p := range pairs {
+		if _, ok := assigned[p.id]; ok || taken[p.floor] {
+			continue
+		}
+		taken[p.floor] = true
+		assigned[p.id] = p.floor
+	}
+	return assigned
+}
@@ -0,0 +1,51 @@
+package parking
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAssignHomeFloors(t *testing.T) {
+	cases := []struct {
+		name       string
+		positions  map[string]float64
+		homeFloors []int
+		expected   map[string]int
+	}{
+		{
+			name:       "single elevator goes to closest home floor",
+			positions:  map[string]float64{"a": 2},
+			homeFloors: []int{0, 3},
+			expected:   map[string]int{"a": 3},
+		},
+		{
+			name:       "elevators at the same floor are spread out",
+			positions:  map[string]float64{"a": 0, "b": 0},
+			homeFloors: []int{0, 3},
+			expected:   map[string]int{"a": 0, "b": 3},
+		},
+		{
+			name:       "closest pair is matched first",
+			positions:  map[string]float64{"a": 1, "b": 0},
+			homeFloors: []int{0, 3},
+			expected:   map[string]int{"a": 3, "b": 0},
+		},
+		{
+			name:       "elevators left over get no home floor",
+			positions:  map[string]float64{"a": 1, "b": 2, "c": 3},
+			homeFloors: []int{0},
+			expected:   map[string]int{"a": 0},
+		},
+		{
+			name:       "no home floors",
+			positions:  map[string]float64{"a": 1},
+			homeFloors: nil,
+			expected:   map[string]int{},
+		},
+	}
+	for _, c := range cases {
+		if assigned := assignHomeFloors(c.positions, c.homeFloors); !reflect.DeepEqual(assigned, c.expected) {
+			t.Errorf("%s: expected %v but got %v\n", c.name, c.expected, assigned)
+		}
+	}
+}
@@ -1,17 +1,63 @@
 package pubsub
 
+// DefaultDiscoveryBasePort is the discovery port of the first topic. The other topics follow consecutively.
+const DefaultDiscoveryBasePort = 41000
+
 const (
-	SalesDiscoveryPort = 41000 + iota
+	SalesDiscoveryPort = DefaultDiscoveryBasePort + iota
 	SoldToDiscoveryPort
 	BidDiscoveryPort
 	AckDiscoveryPort
 	OrderDeliveredDiscoveryPort
 	DbDiscoveryPort
+	ConfigDiscoveryPort
+	TelemetryDiscoveryPort
+	CabRequestDiscoveryPort
+	CabReplyDiscoveryPort
+	endDiscoveryPort
 )
 
+// NumTopics is the number of topics, and thus the number of discovery ports in use.
+const NumTopics = endDiscoveryPort - DefaultDiscoveryBasePort
+
 const SalesTopic = "sales"
 const SoldToTopic = "sold to"
 const BidTopic = "bid"
 const AckTopic = "ack"
 const DbDiscoveryTopic = "db"
 const OrderDeliveredTopic = "order del"
+const ConfigTopic = "config"
+const TelemetryTopic = "telemetry"
+const CabRequestTopic = "cab request"
+const CabReplyTopic = "cab reply"
+
+// DiscoveryPorts holds the discovery port of every topic.
+type DiscoveryPorts struct {
+	Sales          int
+	SoldTo         int
+	Bid            int
+	Ack            int
+	OrderDelivered int
+	Db             int
+	Config         int
+	Telemetry      int
+	CabRequest     int
+	CabReply       int
+}
+
+// GetDiscoveryPorts returns the discovery ports of all topics when counting from basePort.
+func GetDiscoveryPorts(basePort int) DiscoveryPorts {
+	offset := basePort - DefaultDiscoveryBasePort
+	return DiscoveryPorts{
+		Sales:          SalesDiscoveryPort + offset,
+		SoldTo:         SoldToDiscoveryPort + offset,
+		Bid:            BidDiscoveryPort + offset,
+		Ack:            AckDiscoveryPort + offset,
+		OrderDelivered: OrderDeliveredDiscoveryPort + offset,
+		Db:             DbDiscoveryPort + offset,
+		Config:         ConfigDiscoveryPort + offset,
+		Telemetry:      TelemetryDiscoveryPort + offset,
+		CabRequest:     CabRequestDiscoveryPort + offset,
+		CabReply:       CabReplyDiscoveryPort + offset,
+	}
+}
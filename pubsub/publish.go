@@ -2,6 +2,7 @@ package pubsub
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/sigtot/sanntid/hotchan"
 	"github.com/sigtot/sanntid/utils"
@@ -9,6 +10,7 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -29,7 +31,7 @@ func StartPublisher(discoveryPort int) chan []byte {
 	discoveredSubs := make(chan subscriber)
 	go listenForSubscribers(discoveryPort, discoveredSubs)
 	go func() {
-		log := logrus.New()
+		log := utils.NewLogger()
 		subHotChan := hotchan.HotChan{}
 		subHotChan.Start()
 		defer subHotChan.Stop()
@@ -63,11 +65,10 @@ func StartPublisher(discoveryPort int) chan []byte {
 
 // listenForSubscribers listens for heartbeat signals for subscribers, on a designated discovery-port.
 // The discovery port are preassigned to a topic. All active subscribers are passed to the discoveredSubs channel.
+// The port is bound with SO_REUSEADDR so that several elevators on the same host all receive the heartbeats.
 func listenForSubscribers(discoveryPort int, discoveredSubs chan subscriber) {
-	lAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf(":%d", discoveryPort))
-	utils.OkOrPanic(err)
-
-	conn, err := net.ListenUDP("udp", lAddr)
+	lc := net.ListenConfig{Control: reuseAddr}
+	conn, err := lc.ListenPacket(context.Background(), "udp", fmt.Sprintf(":%d", discoveryPort))
 	utils.OkOrPanic(err)
 	defer func() {
 		err := conn.Close()
@@ -76,7 +77,7 @@ func listenForSubscribers(discoveryPort int, discoveredSubs chan subscriber) {
 
 	buf := make([]byte, 1024)
 	for {
-		_, addr, err := conn.ReadFromUDP(buf)
+		_, addr, err := conn.ReadFrom(buf)
 		utils.OkOrPanic(err)
 		topic := strings.TrimRight(string(buf), "\x00") // Trim away zero values from buf when converting to string
 		sub := subscriber{IP: addr.String(), Topic: topic}
@@ -84,6 +85,18 @@ func listenForSubscribers(discoveryPort int, discoveredSubs chan subscriber) {
 	}
 }
 
+// reuseAddr sets SO_REUSEADDR on a socket before it is bound.
+func reuseAddr(network string, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
 // publish posts the body of a messages to the specified address.
 func publish(addr string, body []byte) {
 	resp, err := http.Post(fmt.Sprintf("http://%s", addr), "application/json", bytes.NewBuffer(body))
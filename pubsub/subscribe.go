@@ -13,11 +13,31 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 const aliveSignalInterval = 300
 
+// Discovery modes
+const (
+	DiscoveryBroadcast = "broadcast"
+	DiscoveryLocal     = "local"
+)
+
+// localOnly is set when heartbeats are only sent to subscribers on this machine
+var localOnly int32
+
+// SetDiscoveryMode sets whether heartbeats are broadcast on the network (DiscoveryBroadcast), or only sent
+// to publishers on this machine (DiscoveryLocal). It applies to all running subscribers.
+func SetDiscoveryMode(mode string) {
+	if mode == DiscoveryLocal {
+		atomic.StoreInt32(&localOnly, 1)
+	} else {
+		atomic.StoreInt32(&localOnly, 0)
+	}
+}
+
 // findAvailPort searches for an available port for the tcp connection to use.
 // The ports are randomly selected in a range fro port 10000 to 50000.
 func findAvailPort() (port int) {
@@ -73,10 +93,12 @@ func subHandler(w http.ResponseWriter, r *http.Request, receivedBuffs chan []byt
 
 // sendAliveSignal sends heartbeat signals on the subnet with a predetermined port.
 // The port corresponds to the topic of the subscriber.
+// Heartbeats are sent as loopback broadcasts in local discovery mode, or if the network is unreachable,
+// so that they reach all publishers on this machine.
 func sendAliveSignal(discoveryPort int, publishPort int, topic string) {
 	sAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("255.255.255.255:%d", discoveryPort))
 	utils.OkOrPanic(err)
-	lAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("localhost:%d", discoveryPort))
+	lAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("127.255.255.255:%d", discoveryPort))
 	utils.OkOrPanic(err)
 	conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", publishPort))
 	utils.OkOrPanic(err)
@@ -86,6 +108,12 @@ func sendAliveSignal(discoveryPort int, publishPort int, topic string) {
 	}()
 
 	for {
+		if atomic.LoadInt32(&localOnly) == 1 {
+			_, err = conn.WriteTo([]byte(topic), lAddr)
+			utils.OkOrPanic(err)
+			time.Sleep(aliveSignalInterval * time.Millisecond)
+			continue
+		}
 		_, err = conn.WriteTo([]byte(topic), sAddr)
 		if err != nil {
 			if strings.Contains(err.Error(), "network is unreachable") {
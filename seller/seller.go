@@ -6,16 +6,14 @@ package seller
 
 import (
 	"encoding/json"
+	"github.com/sigtot/sanntid/config"
 	"github.com/sigtot/sanntid/hotchan"
 	"github.com/sigtot/sanntid/pubsub"
 	"github.com/sigtot/sanntid/types"
 	"github.com/sigtot/sanntid/utils"
-	"github.com/sirupsen/logrus"
 	"time"
 )
 
-const ttl = 400
-
 // Seller states
 const (
 	idle = iota
@@ -23,22 +21,22 @@ const (
 	waitingForAck
 )
 
-const biddingRoundDuration = time.Millisecond * 10
-const ackWaitDuration = time.Millisecond * 10
 const moduleName = "SELLER"
 
 // StartSelling starts a seller that sells calls, runs bidding rounds and sells to the lowest bidder.
 // A seller subscribes to bids and sale acknowledgements.
 // A seller publishes sale propositions and sales.
-func StartSelling(newCalls chan types.Call) {
+func StartSelling(cfgStore *config.Store, newCalls chan types.Call) {
 	state := idle
 
-	forSalePubChan := pubsub.StartPublisher(pubsub.SalesDiscoveryPort)
-	soldToPubChan := pubsub.StartPublisher(pubsub.SoldToDiscoveryPort)
-	bidSubChan, _ := pubsub.StartSubscriber(pubsub.BidDiscoveryPort, pubsub.BidTopic)
-	ackSubChan, _ := pubsub.StartSubscriber(pubsub.AckDiscoveryPort, pubsub.AckTopic)
+	cfg := cfgStore.Get()
+	ports := pubsub.GetDiscoveryPorts(cfg.Network.DiscoveryBasePort)
+	forSalePubChan := pubsub.StartPublisher(ports.Sales)
+	soldToPubChan := pubsub.StartPublisher(ports.SoldTo)
+	bidSubChan, _ := pubsub.StartSubscriber(ports.Bid, pubsub.BidTopic)
+	ackSubChan, _ := pubsub.StartSubscriber(ports.Ack, pubsub.AckTopic)
 
-	var log = logrus.New()
+	var log = utils.NewLogger()
 
 	forSale := hotchan.HotChan{}
 	forSale.Start()
@@ -47,7 +45,7 @@ func StartSelling(newCalls chan types.Call) {
 		// Add new calls to queue of orders to sell
 		for {
 			val := <-newCalls
-			hcItem := hotchan.Item{Val: val, TTL: ttl * time.Millisecond}
+			hcItem := hotchan.Item{Val: val, TTL: cfgStore.Get().Seller.SaleTTL}
 			forSale.Insert(hcItem)
 		}
 	}()
@@ -71,7 +69,7 @@ func StartSelling(newCalls chan types.Call) {
 				}
 			case waitingForBids:
 				var recvBids []types.Bid
-				timeOut := time.After(biddingRoundDuration)
+				timeOut := time.After(cfgStore.Get().Seller.BiddingRoundDuration)
 			L1:
 				for {
 					select {
@@ -103,7 +101,7 @@ func StartSelling(newCalls chan types.Call) {
 					}
 				}
 			case waitingForAck:
-				timeOut := time.After(ackWaitDuration)
+				timeOut := time.After(cfgStore.Get().Seller.AckWaitDuration)
 			L2:
 				for {
 					select {
@@ -3,6 +3,7 @@ package seller
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/sigtot/sanntid/config"
 	"github.com/sigtot/sanntid/pubsub"
 	"github.com/sigtot/sanntid/types"
 	"testing"
@@ -16,7 +17,7 @@ func TestSeller(t *testing.T) {
 	bestPrice := 4
 	betterThanBestPrice := 2
 	newCalls := make(chan types.Call)
-	go StartSelling(newCalls)
+	go StartSelling(config.NewStore(config.Default()), newCalls)
 
 	bidPubChan := pubsub.StartPublisher(pubsub.BidDiscoveryPort)
 	ackPubChan := pubsub.StartPublisher(pubsub.AckDiscoveryPort)
@@ -0,0 +1,99 @@
+package simulator
+
+import (
+	"github.com/sigtot/elevio"
+	"io"
+	"net"
+)
+
+// Commands of the elevio TCP protocol. Every message, and every reply, is four bytes long.
+const (
+	cmdMotorDirection = 1
+	cmdButtonLamp     = 2
+	cmdFloorIndicator = 3
+	cmdDoorOpenLamp   = 4
+	cmdStopLamp       = 5
+	cmdGetButton      = 6
+	cmdGetFloor       = 7
+	cmdGetStop        = 8
+	cmdGetObstruction = 9
+)
+
+const msgLen = 4
+
+// ListenAndServe serves the elevio protocol on the TCP address addr, like the physical elevator server does.
+func (sim *Sim) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return sim.Serve(listener)
+}
+
+// Serve accepts connections on listener and serves the elevio protocol on each of them.
+func (sim *Sim) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go sim.handleConn(conn)
+	}
+}
+
+func (sim *Sim) handleConn(conn net.Conn) {
+	defer conn.Close()
+	msg := make([]byte, msgLen)
+	for {
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return
+		}
+		reply := sim.handleMsg(msg)
+		if reply == nil {
+			continue
+		}
+		if _, err := conn.Write(reply); err != nil {
+			return
+		}
+	}
+}
+
+// handleMsg carries out the command in msg, and returns the reply to it, or nil if there is none.
+func (sim *Sim) handleMsg(msg []byte) []byte {
+	switch msg[0] {
+	case cmdMotorDirection:
+		sim.SetMotorDirection(elevio.MotorDirection(int8(msg[1])))
+	case cmdButtonLamp:
+		sim.SetButtonLamp(elevio.ButtonType(msg[1]), int(msg[2]), toBool(msg[3]))
+	case cmdFloorIndicator:
+		sim.SetFloorIndicator(int(msg[1]))
+	case cmdDoorOpenLamp:
+		sim.SetDoorOpenLamp(toBool(msg[1]))
+	case cmdStopLamp:
+		sim.SetStopLamp(toBool(msg[1]))
+	case cmdGetButton:
+		return []byte{cmdGetButton, toByte(sim.ButtonPressed(elevio.ButtonType(msg[1]), int(msg[2]))), 0, 0}
+	case cmdGetFloor:
+		floor := sim.Floor()
+		if floor < 0 {
+			return []byte{cmdGetFloor, 0, 0, 0}
+		}
+		return []byte{cmdGetFloor, 1, byte(floor), 0}
+	case cmdGetStop:
+		return []byte{cmdGetStop, toByte(sim.Stop()), 0, 0}
+	case cmdGetObstruction:
+		return []byte{cmdGetObstruction, toByte(sim.Obstruction()), 0, 0}
+	}
+	return nil
+}
+
+func toBool(b byte) bool {
+	return b != 0
+}
+
+func toByte(value bool) byte {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,281 @@
+/*
+Package simulator implements an elevator simulator, modelling the motor, floor sensor, buttons, lamps, door,
+obstruction switch and stop button of an elevator. The simulator implements driver.Driver, so it can be used
+in-process, and it can serve the elevio TCP protocol, so that elevator nodes can connect to it with -port.
+*/
+package simulator
+
+import (
+	"github.com/sigtot/elevio"
+	"math"
+	"sync"
+	"time"
+)
+
+const pollRate = 20 * time.Millisecond
+
+// Buttons are held down for this long when pressed, so that they are seen by the polling drivers
+const pressDuration = 3 * pollRate
+
+const numButtonTypes = 3
+
+// Config holds the physical properties of the simulated elevator.
+type Config struct {
+	NumFloors     int
+	TravelTime    time.Duration // Time to travel from one floor to the next
+	SensorWidth   float64       // Fraction of the distance between floors where the floor sensor is active
+	StartPosition float64       // Position in floors at startup
+}
+
+// DefaultConfig returns a four floor elevator starting between the two bottom floors.
+func DefaultConfig() Config {
+	return Config{
+		NumFloors:     4,
+		TravelTime:    2 * time.Second,
+		SensorWidth:   0.2,
+		StartPosition: 0.5,
+	}
+}
+
+// Sim is a simulated elevator. The position is calculated from the time passed since the motor last changed.
+type Sim struct {
+	cfg Config
+	mu  sync.Mutex
+
+	pos      float64 // Position when the motor last changed
+	posTime  time.Time
+	motorDir elevio.MotorDirection
+
+	pressedUntil   [][numButtonTypes]time.Time
+	lamps          [][numButtonTypes]bool
+	floorIndicator int
+	doorOpen       bool
+	stopLamp       bool
+	stop           bool
+	obstruction    bool
+}
+
+// New returns a simulated elevator with the properties of cfg.
+func New(cfg Config) *Sim {
+	return &Sim{
+		cfg:          cfg,
+		pos:          cfg.StartPosition,
+		posTime:      time.Now(),
+		motorDir:     elevio.MdStop,
+		pressedUntil: make([][numButtonTypes]time.Time, cfg.NumFloors),
+		lamps:        make([][numButtonTypes]bool, cfg.NumFloors),
+	}
+}
+
+// position returns the current position of the elevator in floors. Must be called with the lock held.
+func (sim *Sim) position() float64 {
+	travelled := float64(time.Since(sim.posTime)) / float64(sim.cfg.TravelTime)
+	pos := sim.pos + float64(sim.motorDir)*travelled
+	return math.Max(0, math.Min(pos, float64(sim.cfg.NumFloors-1)))
+}
+
+// floor returns the floor at which the floor sensor is active, or -1 if between floors.
+// Must be called with the lock held.
+func (sim *Sim) floor() int {
+	pos := sim.position()
+	nearest := math.Round(pos)
+	if math.Abs(pos-nearest) <= sim.cfg.SensorWidth/2 {
+		return int(nearest)
+	}
+	return -1
+}
+
+func (sim *Sim) validFloor(floor int) bool {
+	return floor >= 0 && floor < sim.cfg.NumFloors
+}
+
+func validButton(button elevio.ButtonType) bool {
+	return button >= 0 && button < numButtonTypes
+}
+
+// Position returns the current position of the elevator in floors.
+func (sim *Sim) Position() float64 {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return sim.position()
+}
+
+// Floor returns the floor at which the floor sensor is active, or -1 if between floors.
+func (sim *Sim) Floor() int {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return sim.floor()
+}
+
+// PressButton presses the button at floor. The button is released again after a short while.
+func (sim *Sim) PressButton(button elevio.ButtonType, floor int) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	if sim.validFloor(floor) && validButton(button) {
+		sim.pressedUntil[floor][button] = time.Now().Add(pressDuration)
+	}
+}
+
+// ButtonPressed returns true if the button at floor is held down.
+func (sim *Sim) ButtonPressed(button elevio.ButtonType, floor int) bool {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	if !sim.validFloor(floor) || !validButton(button) {
+		return false
+	}
+	return time.Now().Before(sim.pressedUntil[floor][button])
+}
+
+// SetObstruction sets the state of the obstruction switch.
+func (sim *Sim) SetObstruction(value bool) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.obstruction = value
+}
+
+// Obstruction returns the state of the obstruction switch.
+func (sim *Sim) Obstruction() bool {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return sim.obstruction
+}
+
+// SetStop sets the state of the stop button. The stop button stays pressed until it is released with SetStop(false).
+func (sim *Sim) SetStop(value bool) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.stop = value
+}
+
+// Stop returns the state of the stop button.
+func (sim *Sim) Stop() bool {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return sim.stop
+}
+
+// MotorDirection returns the current direction of the motor.
+func (sim *Sim) MotorDirection() elevio.MotorDirection {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return sim.motorDir
+}
+
+// ButtonLamp returns the state of the lamp of the button at floor.
+func (sim *Sim) ButtonLamp(button elevio.ButtonType, floor int) bool {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	if !sim.validFloor(floor) || !validButton(button) {
+		return false
+	}
+	return sim.lamps[floor][button]
+}
+
+// FloorIndicator returns the floor shown by the floor indicator.
+func (sim *Sim) FloorIndicator() int {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return sim.floorIndicator
+}
+
+// DoorOpen returns the state of the door open lamp.
+func (sim *Sim) DoorOpen() bool {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return sim.doorOpen
+}
+
+// StopLamp returns the state of the stop lamp.
+func (sim *Sim) StopLamp() bool {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return sim.stopLamp
+}
+
+func (sim *Sim) SetMotorDirection(dir elevio.MotorDirection) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.pos = sim.position()
+	sim.posTime = time.Now()
+	sim.motorDir = dir
+}
+
+func (sim *Sim) SetButtonLamp(button elevio.ButtonType, floor int, value bool) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	if sim.validFloor(floor) && validButton(button) {
+		sim.lamps[floor][button] = value
+	}
+}
+
+func (sim *Sim) SetFloorIndicator(floor int) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	if sim.validFloor(floor) {
+		sim.floorIndicator = floor
+	}
+}
+
+func (sim *Sim) SetDoorOpenLamp(value bool) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.doorOpen = value
+}
+
+func (sim *Sim) SetStopLamp(value bool) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.stopLamp = value
+}
+
+// PollButtons sends a button event on receiver every time a button is pressed.
+func (sim *Sim) PollButtons(receiver chan<- elevio.ButtonEvent) {
+	prev := make([][numButtonTypes]bool, sim.cfg.NumFloors)
+	for {
+		time.Sleep(pollRate)
+		for floor := 0; floor < sim.cfg.NumFloors; floor++ {
+			for button := elevio.ButtonType(0); button < numButtonTypes; button++ {
+				pressed := sim.ButtonPressed(button, floor)
+				if pressed && !prev[floor][button] {
+					receiver <- elevio.ButtonEvent{Floor: floor, Button: button}
+				}
+				prev[floor][button] = pressed
+			}
+		}
+	}
+}
+
+// PollFloorSensor sends the floor on receiver every time the floor sensor changes, or -1 when leaving a floor.
+func (sim *Sim) PollFloorSensor(receiver chan<- int) {
+	prev := -2
+	for {
+		time.Sleep(pollRate)
+		floor := sim.Floor()
+		if floor != prev {
+			receiver <- floor
+		}
+		prev = floor
+	}
+}
+
+// PollStopButton sends the state of the stop button on receiver every time it changes.
+func (sim *Sim) PollStopButton(receiver chan<- bool) {
+	pollBool(sim.Stop, receiver)
+}
+
+// PollObstructionSwitch sends the state of the obstruction switch on receiver every time it changes.
+func (sim *Sim) PollObstructionSwitch(receiver chan<- bool) {
+	pollBool(sim.Obstruction, receiver)
+}
+
+func pollBool(get func() bool, receiver chan<- bool) {
+	prev := false
+	for {
+		time.Sleep(pollRate)
+		value := get()
+		if value != prev {
+			receiver <- value
+		}
+		prev = value
+	}
+}
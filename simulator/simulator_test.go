@@ -0,0 +1,145 @@
+package simulator
+
+import (
+	"github.com/sigtot/elevio"
+	"github.com/sigtot/sanntid/driver"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+const testTravelTime = 200 * time.Millisecond
+
+var _ driver.Driver = &Sim{}
+
+func newTestSim(startPosition float64) *Sim {
+	cfg := DefaultConfig()
+	cfg.TravelTime = testTravelTime
+	cfg.SensorWidth = 0.4
+	cfg.StartPosition = startPosition
+	return New(cfg)
+}
+
+func expectFloor(t *testing.T, floors <-chan int, expected int) {
+	select {
+	case floor := <-floors:
+		if floor != expected {
+			t.Fatalf("Expected floor sensor %d but got %d\n", expected, floor)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for floor sensor %d\n", expected)
+	}
+}
+
+func TestMotion(t *testing.T) {
+	sim := newTestSim(1)
+	floors := make(chan int)
+	go sim.PollFloorSensor(floors)
+	expectFloor(t, floors, 1)
+
+	sim.SetMotorDirection(elevio.MdUp)
+	expectFloor(t, floors, -1)
+	expectFloor(t, floors, 2)
+	sim.SetMotorDirection(elevio.MdStop)
+	if sim.Floor() != 2 {
+		t.Fatalf("Expected elevator to stop at floor 2 but is at %f\n", sim.Position())
+	}
+
+	sim.SetMotorDirection(elevio.MdDown)
+	expectFloor(t, floors, -1)
+	expectFloor(t, floors, 1)
+	expectFloor(t, floors, -1)
+	expectFloor(t, floors, 0)
+	time.Sleep(testTravelTime)
+	if sim.Position() != 0 {
+		t.Fatalf("Expected elevator to stay at the bottom floor but is at %f\n", sim.Position())
+	}
+}
+
+func TestPollButtons(t *testing.T) {
+	sim := newTestSim(0)
+	buttonEvents := make(chan elevio.ButtonEvent)
+	go sim.PollButtons(buttonEvents)
+
+	sim.PressButton(elevio.BtnHallDown, 3)
+	select {
+	case event := <-buttonEvents:
+		if event.Floor != 3 || event.Button != elevio.BtnHallDown {
+			t.Fatalf("Expected hall down button at floor 3 but got %+v\n", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for button press")
+	}
+	select {
+	case event := <-buttonEvents:
+		t.Fatalf("Expected a single button event but got %+v\n", event)
+	case <-time.After(2 * pressDuration):
+	}
+}
+
+func TestServe(t *testing.T) {
+	sim := newTestSim(2)
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go sim.Serve(listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	send := func(msg ...byte) {
+		if _, err := conn.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	request := func(msg ...byte) []byte {
+		send(msg...)
+		reply := make([]byte, msgLen)
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+
+	send(cmdButtonLamp, byte(elevio.BtnCab), 1, 1)
+	send(cmdDoorOpenLamp, 1, 0, 0)
+	send(cmdFloorIndicator, 2, 0, 0)
+	send(cmdMotorDirection, byte(0xff), 0, 0) // -1, down
+
+	sim.SetObstruction(true)
+	if reply := request(cmdGetObstruction, 0, 0, 0); reply[1] != 1 {
+		t.Fatalf("Expected obstruction but got reply %v\n", reply)
+	}
+	if reply := request(cmdGetStop, 0, 0, 0); reply[1] != 0 {
+		t.Fatalf("Expected no stop but got reply %v\n", reply)
+	}
+	if !sim.ButtonLamp(elevio.BtnCab, 1) || !sim.DoorOpen() || sim.FloorIndicator() != 2 {
+		t.Fatal("Lamps were not set")
+	}
+	if sim.MotorDirection() != elevio.MdDown {
+		t.Fatalf("Expected motor direction down but got %d\n", sim.MotorDirection())
+	}
+
+	time.Sleep(testTravelTime / 2)
+	if reply := request(cmdGetFloor, 0, 0, 0); reply[1] != 0 {
+		t.Fatalf("Expected to be between floors but got reply %v\n", reply)
+	}
+	timeout := time.After(time.Second)
+	for reply := request(cmdGetFloor, 0, 0, 0); reply[1] == 0; reply = request(cmdGetFloor, 0, 0, 0) {
+		select {
+		case <-timeout:
+			t.Fatal("Timed out waiting for the floor sensor")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	send(cmdMotorDirection, 0, 0, 0)
+	if reply := request(cmdGetFloor, 0, 0, 0); reply[1] != 1 || reply[2] != 1 {
+		t.Fatalf("Expected to be at floor 1 but got reply %v\n", reply)
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Package telemetry periodically publishes the state of this elevator on the network: its position, direction,
+door, status and queue. It is meant for monitoring tools, and for other nodes that want to know where the
+elevators are.
+*/
+package telemetry
+
+import (
+	"encoding/json"
+	"github.com/sigtot/elevio"
+	"github.com/sigtot/sanntid/config"
+	"github.com/sigtot/sanntid/pubsub"
+	"github.com/sigtot/sanntid/types"
+	"github.com/sigtot/sanntid/utils"
+	"time"
+)
+
+// Elevator is the interface to the elevator controller, used to read the state of the elevator.
+type Elevator interface {
+	GetPos() float64
+	GetDir() elevio.MotorDirection
+	DoorOpen() bool
+	Status() types.ElevatorStatus
+}
+
+// Queue is the interface to the order handler, used to read the state of the order queue.
+type Queue interface {
+	QueueLength() int
+	NextGoal() (types.Order, bool)
+}
+
+// StartPublishing starts publishing the state of elevatorID, as given by elev and queue,
+// every telemetry interval.
+func StartPublishing(cfgStore *config.Store, elevatorID string, elev Elevator, queue Queue) {
+	cfg := cfgStore.Get()
+	ports := pubsub.GetDiscoveryPorts(cfg.Network.DiscoveryBasePort)
+	telemetryPubChan := pubsub.StartPublisher(ports.Telemetry)
+
+	go func() {
+		for {
+			time.Sleep(cfgStore.Get().Cluster.TelemetryInterval)
+			js, err := json.Marshal(GetState(elevatorID, elev, queue))
+			utils.OkOrPanic(err)
+			telemetryPubChan <- js
+		}
+	}()
+}
+
+// GetState returns the current state of elevatorID, as given by elev and queue.
+func GetState(elevatorID string, elev Elevator, queue Queue) types.ElevatorState {
+	status := elev.Status()
+	status.ElevatorID = elevatorID
+	state := types.ElevatorState{
+		ElevatorID:  elevatorID,
+		Position:    elev.GetPos(),
+		Dir:         elev.GetDir(),
+		DoorOpen:    elev.DoorOpen(),
+		Status:      status,
+		QueueLength: queue.QueueLength(),
+	}
+	if nextGoal, ok := queue.NextGoal(); ok {
+		state.NextGoal = &nextGoal
+	}
+	return state
+}
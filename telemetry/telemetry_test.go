@@ -0,0 +1,66 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"github.com/sigtot/elevio"
+	"github.com/sigtot/sanntid/types"
+	"reflect"
+	"testing"
+)
+
+type mockElevator struct {
+	pos    float64
+	dir    elevio.MotorDirection
+	door   bool
+	status types.ElevatorStatus
+}
+
+func (e mockElevator) GetPos() float64               { return e.pos }
+func (e mockElevator) GetDir() elevio.MotorDirection { return e.dir }
+func (e mockElevator) DoorOpen() bool                { return e.door }
+func (e mockElevator) Status() types.ElevatorStatus  { return e.status }
+
+type mockQueue []types.Order
+
+func (q mockQueue) QueueLength() int { return len(q) }
+func (q mockQueue) NextGoal() (types.Order, bool) {
+	if len(q) == 0 {
+		return types.Order{}, false
+	}
+	return q[0], true
+}
+
+func TestGetState(t *testing.T) {
+	goal := types.Order{Call: types.Call{Type: types.Hall, Floor: 3, Dir: types.Down}}
+	elev := mockElevator{pos: 1.5, dir: elevio.MdUp, status: types.ElevatorStatus{Faulty: true}}
+	state := GetState("elev1", elev, mockQueue{goal, {}})
+	expected := types.ElevatorState{
+		ElevatorID:  "elev1",
+		Position:    1.5,
+		Dir:         elevio.MdUp,
+		Status:      types.ElevatorStatus{ElevatorID: "elev1", Faulty: true},
+		QueueLength: 2,
+		NextGoal:    &goal,
+	}
+	if !reflect.DeepEqual(state, expected) {
+		t.Fatalf("Expected %+v but got %+v\n", expected, state)
+	}
+
+	// The state survives a round trip over the network
+	js, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received types.ElevatorState
+	if err := json.Unmarshal(js, &received); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(received, expected) {
+		t.Fatalf("Expected %+v but got %+v\n", expected, received)
+	}
+
+	state = GetState("elev1", mockElevator{door: true}, mockQueue{})
+	if state.NextGoal != nil || state.QueueLength != 0 || !state.DoorOpen {
+		t.Fatalf("Expected open door and empty queue but got %+v\n", state)
+	}
+}
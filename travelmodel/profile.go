@@ -0,0 +1,54 @@
+package travelmodel
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+)
+
+const profileFileName = "building_profile.json"
+const profileFilePerms = 0600
+
+// Profile describes the building as found by a calibration run: the number of floors,
+// the travel time between every pair of adjacent floors and the time the door is open at a stop.
+type Profile struct {
+	NumFloors int
+	Segments  []time.Duration // Segments[k] is the travel time between floor k and k+1
+	DoorDwell time.Duration
+}
+
+// ProfilePath returns the path of the building profile in dataDir.
+func ProfilePath(dataDir string) string {
+	return filepath.Join(dataDir, profileFileName)
+}
+
+// LoadProfile returns the building profile saved at path, and false if there is none.
+func LoadProfile(path string) (Profile, bool) {
+	var profile Profile
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return profile, false
+	}
+	if err := json.Unmarshal(buf, &profile); err != nil {
+		return profile, false
+	}
+	valid := profile.NumFloors >= 2 && len(profile.Segments) == profile.NumFloors-1
+	return profile, valid
+}
+
+// SaveProfile saves profile at path.
+func SaveProfile(path string, profile Profile) error {
+	js, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, js, profileFilePerms)
+}
+
+// NewFromProfile returns a model with the travel and door times of profile as initial estimates.
+func NewFromProfile(profile Profile) *Model {
+	segments := make([]time.Duration, len(profile.Segments))
+	copy(segments, profile.Segments)
+	return &Model{segments: segments, doorDwell: profile.DoorDwell}
+}
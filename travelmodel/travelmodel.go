@@ -0,0 +1,100 @@
+/*
+Package travelmodel keeps an estimate of how long this elevator takes to travel between floors and to serve a stop.
+The estimates start out from configured values, and are refined by the travel and door times measured by the
+elevator controller, so that prices reflect the actual speed of the elevator.
+*/
+package travelmodel
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Weight of a new measurement in the moving averages
+const smoothing = 0.3
+
+// Model estimates the travel time between every pair of adjacent floors, and the time the door is open at a stop.
+// It is safe for concurrent use.
+type Model struct {
+	mu        sync.RWMutex
+	segments  []time.Duration // segments[k] is the travel time between floor k and k+1
+	doorDwell time.Duration
+}
+
+// New returns a model for numFloors floors, initially estimating floorTime between adjacent floors
+// and doorDwell at every stop.
+func New(numFloors int, floorTime time.Duration, doorDwell time.Duration) *Model {
+	segments := make([]time.Duration, numFloors-1)
+	for k := range segments {
+		segments[k] = floorTime
+	}
+	return &Model{segments: segments, doorDwell: doorDwell}
+}
+
+// RecordSegment updates the estimated travel time between the adjacent floors from and to with a measurement.
+func (m *Model) RecordSegment(from int, to int, d time.Duration) {
+	k := from
+	if to < from {
+		k = to
+	}
+	if math.Abs(float64(to-from)) != 1 || k < 0 || d <= 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if k < len(m.segments) {
+		m.segments[k] = average(m.segments[k], d)
+	}
+}
+
+// RecordDoorDwell updates the estimated time the door is open at a stop with a measurement.
+func (m *Model) RecordDoorDwell(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.doorDwell = average(m.doorDwell, d)
+}
+
+// TravelTime returns the estimated time to travel between the positions from and to, given in floors.
+func (m *Model) TravelTime(from float64, to float64) time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return time.Duration(math.Abs(float64(m.distance(to) - m.distance(from))))
+}
+
+// DoorDwell returns the estimated time the door is open at a stop.
+func (m *Model) DoorDwell() time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.doorDwell
+}
+
+// Segments returns the estimated travel times between adjacent floors.
+func (m *Model) Segments() []time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	segments := make([]time.Duration, len(m.segments))
+	copy(segments, m.segments)
+	return segments
+}
+
+// distance returns the estimated travel time from the bottom floor to pos. Must be called with the lock held.
+func (m *Model) distance(pos float64) time.Duration {
+	pos = math.Max(0, math.Min(pos, float64(len(m.segments))))
+	var d time.Duration
+	k := 0
+	for ; float64(k+1) <= pos; k++ {
+		d += m.segments[k]
+	}
+	if k < len(m.segments) {
+		d += time.Duration((pos - float64(k)) * float64(m.segments[k]))
+	}
+	return d
+}
+
+func average(estimate time.Duration, measurement time.Duration) time.Duration {
+	return estimate + time.Duration(smoothing*float64(measurement-estimate))
+}
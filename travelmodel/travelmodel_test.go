@@ -0,0 +1,81 @@
+package travelmodel
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTravelTime(t *testing.T) {
+	m := New(4, 2*time.Second, 3*time.Second)
+	cases := []struct {
+		from, to float64
+		expected time.Duration
+	}{
+		{0, 3, 6 * time.Second},
+		{3, 0, 6 * time.Second},
+		{1.5, 2, time.Second},
+		{2, 2, 0},
+		{-1, 5, 6 * time.Second}, // Clamped to the shaft
+	}
+	for _, c := range cases {
+		if d := m.TravelTime(c.from, c.to); d != c.expected {
+			t.Errorf("Expected travel time %s from %.1f to %.1f but got %s\n", c.expected, c.from, c.to, d)
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	m := New(4, 2*time.Second, 3*time.Second)
+	for i := 0; i < 50; i++ {
+		m.RecordSegment(2, 1, 4*time.Second)
+		m.RecordDoorDwell(5 * time.Second)
+	}
+	if d := m.TravelTime(1, 2); d < 3900*time.Millisecond || d > 4*time.Second {
+		t.Fatalf("Expected travel time between floor 1 and 2 to approach 4s but got %s\n", d)
+	}
+	if d := m.TravelTime(0, 1); d != 2*time.Second {
+		t.Fatalf("Expected travel time between floor 0 and 1 to be unchanged but got %s\n", d)
+	}
+	if d := m.DoorDwell(); d < 4900*time.Millisecond || d > 5*time.Second {
+		t.Fatalf("Expected door dwell to approach 5s but got %s\n", d)
+	}
+
+	// Measurements not between adjacent floors are ignored
+	m.RecordSegment(0, 2, time.Second)
+	m.RecordSegment(3, 4, time.Second)
+	if d := m.TravelTime(0, 1); d != 2*time.Second {
+		t.Fatalf("Expected travel time between floor 0 and 1 to be unchanged but got %s\n", d)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := ProfilePath(dir)
+	if _, ok := LoadProfile(path); ok {
+		t.Fatal("Expected no profile before saving")
+	}
+
+	profile := Profile{NumFloors: 3, Segments: []time.Duration{time.Second, 3 * time.Second}, DoorDwell: 2 * time.Second}
+	if err := SaveProfile(path, profile); err != nil {
+		t.Fatal(err)
+	}
+	loaded, ok := LoadProfile(path)
+	if !ok || !reflect.DeepEqual(loaded, profile) {
+		t.Fatalf("Expected profile %+v but got %+v\n", profile, loaded)
+	}
+
+	m := NewFromProfile(loaded)
+	if d := m.TravelTime(0, 2); d != 4*time.Second {
+		t.Fatalf("Expected travel time 4s but got %s\n", d)
+	}
+	if d := m.DoorDwell(); d != 2*time.Second {
+		t.Fatalf("Expected door dwell 2s but got %s\n", d)
+	}
+}
@@ -1,5 +1,7 @@
 package types
 
+import "github.com/sigtot/elevio"
+
 // Direction is the direction of the call.
 type Direction int
 
@@ -49,3 +51,28 @@ type SoldTo struct {
 type Ack struct {
 	Bid
 }
+
+// ElevatorStatus tells whether an elevator is able to deliver hall orders. It is part of the published ElevatorState.
+type ElevatorStatus struct {
+	ElevatorID   string
+	Halted       bool // The stop button is pressed
+	Faulty       bool // The motor or floor sensor has failed
+	Disconnected bool // The connection to the elevator server is lost
+	Maintenance  bool // Taken out of service by an operator, or shutting down
+}
+
+// Available returns true if the elevator is able to deliver hall orders.
+func (status ElevatorStatus) Available() bool {
+	return !status.Halted && !status.Faulty && !status.Disconnected && !status.Maintenance
+}
+
+// ElevatorState is published periodically by every elevator to tell where it is and what it is doing.
+type ElevatorState struct {
+	ElevatorID  string
+	Position    float64               // Floor number, or halfway between two floors
+	Dir         elevio.MotorDirection // Direction of travel, or elevio.MdStop when idle
+	DoorOpen    bool
+	Status      ElevatorStatus
+	QueueLength int
+	NextGoal    *Order // Nil when the queue is empty
+}
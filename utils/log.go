@@ -3,11 +3,45 @@ package utils
 import (
 	"github.com/sigtot/sanntid/types"
 	"github.com/sirupsen/logrus"
+	"sync"
 )
 
 // log string is used to format fixed width module print
 const logString = "%-15s%s"
 
+// loggers holds all loggers created by NewLogger, so that SetLogLevel can change the level of all of them.
+var loggers = struct {
+	all   []*logrus.Logger
+	level logrus.Level
+	mu    sync.Mutex
+}{level: logrus.InfoLevel}
+
+// NewLogger returns a new logger at the current log level
+func NewLogger() *logrus.Logger {
+	loggers.mu.Lock()
+	defer loggers.mu.Unlock()
+	log := logrus.New()
+	log.SetLevel(loggers.level)
+	loggers.all = append(loggers.all, log)
+	return log
+}
+
+// SetLogLevel sets the level of all loggers created by NewLogger, and of the standard logger
+func SetLogLevel(level string) error {
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	loggers.mu.Lock()
+	defer loggers.mu.Unlock()
+	loggers.level = lvl
+	for _, log := range loggers.all {
+		log.SetLevel(lvl)
+	}
+	logrus.SetLevel(lvl)
+	return nil
+}
+
 // LogBid prints a bid to the terminal
 func LogBid(log *logrus.Logger, moduleName string, info string, bid types.Bid) {
 	log.WithFields(logrus.Fields{
@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"github.com/sirupsen/logrus"
+	"testing"
+)
+
+func TestSetLogLevel(t *testing.T) {
+	before := NewLogger()
+	if err := SetLogLevel("warn"); err != nil {
+		t.Fatal(err)
+	}
+	defer SetLogLevel("info")
+	after := NewLogger()
+
+	if before.GetLevel() != logrus.WarnLevel || after.GetLevel() != logrus.WarnLevel {
+		t.Fatalf("Expected warn level, but got %s and %s\n", before.GetLevel(), after.GetLevel())
+	}
+	if err := SetLogLevel("loud"); err == nil {
+		t.Fatal("Expected error on invalid level")
+	}
+}